@@ -0,0 +1,207 @@
+// Package accesslog write a JSON line for every proxied stream, it is separated from the
+// diagnostic log.
+package accesslog
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	errors "golang.org/x/xerrors"
+)
+
+// Stdout is the path to write the access log to stdout.
+const Stdout = "stdout"
+
+// Entry is an access log line, bytes sent are from client to target, bytes received are from
+// target to client.
+type Entry struct {
+	ID            uint64    `json:"id"`
+	Side          string    `json:"side"`
+	Source        string    `json:"source"`
+	Target        string    `json:"target"`
+	User          string    `json:"user,omitempty"`
+	Start         time.Time `json:"start"`
+	End           time.Time `json:"end"`
+	Duration      float64   `json:"duration"` // seconds
+	BytesSent     uint64    `json:"bytes_sent"`
+	BytesReceived uint64    `json:"bytes_received"`
+	Reason        string    `json:"reason"`
+}
+
+// Logger write entries as JSON lines, a nil Logger discards all entries.
+type Logger struct {
+	side string
+
+	mutex   sync.Mutex
+	writer  io.Writer
+	encoder *json.Encoder
+}
+
+// New create a Logger of side writing to path, path can be Stdout, if path is empty, return nil.
+func New(side, path string) (*Logger, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	var writer io.Writer = os.Stdout
+
+	if path != Stdout {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, errors.Errorf("open access log %s failed: %w", path, err)
+		}
+
+		writer = file
+	}
+
+	return &Logger{
+		side:    side,
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}, nil
+}
+
+func (l *Logger) write(entry Entry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.encoder.Encode(entry); err != nil {
+		err = errors.Errorf("write access log failed: %w", err)
+		log.Warnf("%v", err)
+	}
+}
+
+// Close close the log file, the entries after Close are discarded.
+func (l *Logger) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.encoder = json.NewEncoder(io.Discard)
+
+	if file, ok := l.writer.(*os.File); ok && file != os.Stdout {
+		return file.Close()
+	}
+
+	return nil
+}
+
+// Start record a stream from source to target, the entry is written when End is called.
+func (l *Logger) Start(id uint64, source, target, user string) *Stream {
+	return &Stream{
+		logger: l,
+		entry: Entry{
+			ID:     id,
+			Source: source,
+			Target: target,
+			User:   user,
+			Start:  time.Now(),
+		},
+	}
+}
+
+// Stream count the bytes of a proxied stream and record the close reason.
+type Stream struct {
+	logger *Logger
+	entry  Entry
+
+	Sent     atomic.Uint64
+	Received atomic.Uint64
+
+	reasonOnce sync.Once
+	endOnce    sync.Once
+}
+
+// SetUser record the user of the stream when it is known after Start.
+func (s *Stream) SetUser(user string) {
+	s.entry.User = user
+}
+
+// SetReason record why the stream is closed, only the first reason is kept, such as the side
+// which closed first.
+func (s *Stream) SetReason(reason string) {
+	s.reasonOnce.Do(func() {
+		s.entry.Reason = reason
+	})
+}
+
+// Reason return the close reason of side, if err is nil or EOF, side closed normally.
+func Reason(side string, err error) string {
+	if err == nil || errors.Is(err, io.EOF) {
+		return side + " closed"
+	}
+
+	return side + " error: " + err.Error()
+}
+
+// End write the entry of the stream, only the first call writes.
+func (s *Stream) End() {
+	s.endOnce.Do(func() {
+		if s.logger == nil {
+			return
+		}
+
+		s.SetReason("closed")
+
+		entry := s.entry
+		entry.Side = s.logger.side
+		entry.End = time.Now()
+		entry.Duration = entry.End.Sub(entry.Start).Seconds()
+		entry.BytesSent = s.Sent.Load()
+		entry.BytesReceived = s.Received.Load()
+
+		s.logger.write(entry)
+	})
+}
+
+// Conn count the bytes of conn which connects to target, the entry is written when conn is closed.
+func (s *Stream) Conn(conn net.Conn) net.Conn {
+	return &streamConn{
+		Conn:   conn,
+		stream: s,
+	}
+}
+
+type streamConn struct {
+	net.Conn
+
+	stream *Stream
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stream.Received.Add(uint64(n))
+
+	return n, err
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stream.Sent.Add(uint64(n))
+
+	return n, err
+}
+
+func (c *streamConn) Close() error {
+	err := c.Conn.Close()
+
+	c.stream.End()
+
+	return err
+}
+
+// SetReason record the close reason if conn is returned by Stream.Conn.
+func SetReason(conn net.Conn, reason string) {
+	if sc, ok := conn.(*streamConn); ok {
+		sc.stream.SetReason(reason)
+	}
+}
@@ -0,0 +1,291 @@
+// Package certstore select the server certificate by SNI, reload the certificate files when
+// they are changed and warn before the certificates expire.
+package certstore
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	errors "golang.org/x/xerrors"
+)
+
+const (
+	defaultCheckInterval = 30 * time.Second
+	defaultExpiryWarning = 14 * 24 * time.Hour
+
+	// expiryWarnInterval limit how often the expiry warning of a certificate is logged
+	expiryWarnInterval = 24 * time.Hour
+)
+
+type KeyPair struct {
+	CrtFile string
+	KeyFile string
+}
+
+type Option interface {
+	apply(s *Store)
+}
+
+type checkInterval time.Duration
+
+func (c checkInterval) apply(s *Store) {
+	if c > 0 {
+		s.checkInterval = time.Duration(c)
+	}
+}
+
+// WithCheckInterval set how often the certificate files are checked.
+func WithCheckInterval(interval time.Duration) Option {
+	return checkInterval(interval)
+}
+
+type expiryWarning time.Duration
+
+func (e expiryWarning) apply(s *Store) {
+	if e > 0 {
+		s.expiryWarning = time.Duration(e)
+	}
+}
+
+// WithExpiryWarning set how long before expiry the warning is logged.
+func WithExpiryWarning(before time.Duration) Option {
+	return expiryWarning(before)
+}
+
+type entry struct {
+	KeyPair
+
+	cert    *tls.Certificate
+	crtStat fileStat
+	keyStat fileStat
+
+	lastWarn time.Time
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func (f fileStat) equal(other fileStat) bool {
+	return f.modTime.Equal(other.modTime) && f.size == other.size
+}
+
+// Store is a certificate store, the first key pair is the default certificate when no one
+// matches SNI.
+type Store struct {
+	// entries is replaced as a whole, entry.cert is replaced under mutex
+	entries atomic.Value // []*entry
+	mutex   sync.Mutex
+
+	checkInterval time.Duration
+	expiryWarning time.Duration
+
+	watchOnce sync.Once
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+func New(opts ...Option) *Store {
+	s := &Store{
+		checkInterval: defaultCheckInterval,
+		expiryWarning: defaultExpiryWarning,
+		closeChan:     make(chan struct{}),
+	}
+
+	s.entries.Store([]*entry(nil))
+
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+
+	return s
+}
+
+// Load load key pairs and replace all certificates, if any key pair fails, nothing is changed.
+func (s *Store) Load(pairs ...KeyPair) error {
+	if len(pairs) == 0 {
+		return errors.New("no certificate")
+	}
+
+	entries := make([]*entry, 0, len(pairs))
+
+	for _, pair := range pairs {
+		e := &entry{KeyPair: pair}
+
+		if err := e.load(); err != nil {
+			return err
+		}
+
+		entries = append(entries, e)
+	}
+
+	s.mutex.Lock()
+	s.entries.Store(entries)
+	s.mutex.Unlock()
+
+	s.checkExpiry()
+
+	return nil
+}
+
+// GetCertificate is tls.Config.GetCertificate, return the first certificate which matches SNI,
+// or the default certificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	entries := s.entries.Load().([]*entry)
+	if len(entries) == 0 {
+		return nil, errors.New("no certificate")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if hello.ServerName != "" {
+		for _, e := range entries {
+			if e.cert.Leaf.VerifyHostname(hello.ServerName) == nil {
+				return e.cert, nil
+			}
+		}
+	}
+
+	return entries[0].cert, nil
+}
+
+// Watch check the certificate files every check interval until Close, changed files are
+// reloaded, if reload failed, the old certificate is kept.
+func (s *Store) Watch() {
+	s.watchOnce.Do(func() {
+		go s.watch()
+	})
+}
+
+func (s *Store) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
+
+	return nil
+}
+
+func (s *Store) watch() {
+	ticker := time.NewTicker(s.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.closeChan:
+			return
+
+		case <-ticker.C:
+		}
+
+		s.reloadChanged()
+		s.checkExpiry()
+	}
+}
+
+func (s *Store) reloadChanged() {
+	for _, e := range s.entries.Load().([]*entry) {
+		crtStat, keyStat, err := e.stat()
+		if err != nil {
+			log.Warnf("check certificate %s failed: %v", e.CrtFile, err)
+			continue
+		}
+
+		s.mutex.Lock()
+		changed := !crtStat.equal(e.crtStat) || !keyStat.equal(e.keyStat)
+		s.mutex.Unlock()
+
+		if !changed {
+			continue
+		}
+
+		reloaded := &entry{KeyPair: e.KeyPair}
+		if err := reloaded.load(); err != nil {
+			// certificate and key may be written one by one, retry next time
+			log.Warnf("reload certificate %s failed, keep the old one: %v", e.CrtFile, err)
+			continue
+		}
+
+		s.mutex.Lock()
+		e.cert = reloaded.cert
+		e.crtStat = reloaded.crtStat
+		e.keyStat = reloaded.keyStat
+		e.lastWarn = time.Time{}
+		s.mutex.Unlock()
+
+		log.Infof("reload certificate %s, expire at %s", e.CrtFile, e.cert.Leaf.NotAfter.Format(time.RFC3339))
+	}
+}
+
+func (s *Store) checkExpiry() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+
+	for _, e := range s.entries.Load().([]*entry) {
+		notAfter := e.cert.Leaf.NotAfter
+
+		if notAfter.Sub(now) > s.expiryWarning || now.Sub(e.lastWarn) < expiryWarnInterval {
+			continue
+		}
+
+		e.lastWarn = now
+
+		if now.After(notAfter) {
+			log.Errorf("certificate %s %v expired at %s", e.CrtFile, e.cert.Leaf.DNSNames, notAfter.Format(time.RFC3339))
+		} else {
+			log.Warnf("certificate %s %v will expire at %s", e.CrtFile, e.cert.Leaf.DNSNames, notAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+func (e *entry) load() error {
+	crtStat, keyStat, err := e.stat()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(e.CrtFile, e.KeyFile)
+	if err != nil {
+		return errors.Errorf("load key pair %s %s failed: %w", e.CrtFile, e.KeyFile, err)
+	}
+
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return errors.Errorf("parse certificate %s failed: %w", e.CrtFile, err)
+		}
+
+		cert.Leaf = leaf
+	}
+
+	e.cert = &cert
+	e.crtStat = crtStat
+	e.keyStat = keyStat
+
+	return nil
+}
+
+func (e *entry) stat() (crtStat, keyStat fileStat, err error) {
+	crtInfo, err := os.Stat(e.CrtFile)
+	if err != nil {
+		return fileStat{}, fileStat{}, errors.Errorf("stat %s failed: %w", e.CrtFile, err)
+	}
+
+	keyInfo, err := os.Stat(e.KeyFile)
+	if err != nil {
+		return fileStat{}, fileStat{}, errors.Errorf("stat %s failed: %w", e.KeyFile, err)
+	}
+
+	crtStat = fileStat{modTime: crtInfo.ModTime(), size: crtInfo.Size()}
+	keyStat = fileStat{modTime: keyInfo.ModTime(), size: keyInfo.Size()}
+
+	return crtStat, keyStat, nil
+}
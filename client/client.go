@@ -2,117 +2,377 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"net/url"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/Sherlock-Holo/camouflage/accesslog"
 	"github.com/Sherlock-Holo/camouflage/config/client"
+	"github.com/Sherlock-Holo/camouflage/metrics"
+	"github.com/Sherlock-Holo/camouflage/proto"
 	"github.com/Sherlock-Holo/camouflage/session"
+	quic "github.com/Sherlock-Holo/camouflage/session/quic/client"
+	"github.com/Sherlock-Holo/camouflage/session/upstream"
 	wsslink "github.com/Sherlock-Holo/camouflage/session/wsslink/client"
 	"github.com/Sherlock-Holo/libsocks"
 	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
 	errors "golang.org/x/xerrors"
 )
 
 type Client struct {
-	listener    net.Listener
-	session     session.Client
-	connReqChan chan *connRequest
-	timeout     time.Duration
+	// cfg, listener, httpListener and forwards are guarded by reloadMutex
+	cfg          client.Config
+	listener     net.Listener
+	httpListener net.Listener
+	forwards     map[string]*forwardListener // listen addr -> listener
+	reloadMutex  sync.Mutex
+
+	// mixed sniff the listener conns, HTTP conns are pushed to mixedHTTPListener
+	mixed             atomic.Bool
+	mixedHTTPListener *chanListener
+
+	session atomic.Value // *clientSession
+	timeout atomic.Duration
+
+	// dialLimit bound the number of concurrent session stream opens
+	dialLimit chan struct{}
+
+	// router decide whether a target is proxied, dialed directly or rejected
+	router atomic.Value // *router
+
+	// httpProxy is shared by all HTTP listeners, httpServers are the running servers of them
+	httpProxy   *httpProxy
+	httpServers sync.Map // *http.Server
+
+	accessLog *accesslog.Logger
+	connIdGen atomic.Uint64
+
+	// transparentListener accept the conns redirected by iptables, nil when it is disabled
+	transparentListener net.Listener
+
+	// dns resolve the DNS queries through the session, nil when it is disabled
+	dns *dnsServer
+
+	// reverseConns are the listen streams of reverse tunnels
+	reverseConns sync.Map // net.Conn
+
+	// active is the number of dialed conns, proxied or direct
+	active    atomic.Int64
+	closed    atomic.Bool
+	closeChan chan struct{}
 }
 
 func New(cfg *client.Config) (*Client, error) {
+	accessLog, err := accesslog.New("client", cfg.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+
 	listener, err := net.Listen("tcp", cfg.ListenAddr)
 	if err != nil {
 		err = errors.Errorf("local listen failed: %w", err)
 		log.Fatalf("%+v", err)
 	}
 
+	maxDials := cfg.MaxDials
+	if maxDials <= 0 {
+		maxDials = defaultMaxDials
+	}
+
 	cl := &Client{
-		listener:    listener,
-		connReqChan: make(chan *connRequest, 50),
+		cfg:               *cfg,
+		listener:          listener,
+		mixedHTTPListener: newChanListener(listener.Addr()),
+		dialLimit:         make(chan struct{}, maxDials),
+		closeChan:         make(chan struct{}),
+		accessLog:         accessLog,
+	}
+
+	forwards, err := cl.listenForwards(cfg.Forward)
+	if err != nil {
+		return nil, err
+	}
+
+	cl.forwards = forwards
+
+	if cfg.Transparent.ListenAddr != "" {
+		transparentListener, err := listenTransparent(cfg.Transparent.ListenAddr, cfg.Transparent.Mode)
+		if err != nil {
+			return nil, err
+		}
+
+		cl.transparentListener = transparentListener
+	}
+
+	if cfg.DNS.ListenAddr != "" {
+		dnsServer, err := cl.newDNSServer(cfg.DNS)
+		if err != nil {
+			return nil, err
+		}
+
+		cl.dns = dnsServer
+	}
+
+	cl.httpProxy = newHTTPProxy(cl)
+
+	cl.mixed.Store(cfg.Mixed)
+
+	if cfg.HTTP.ListenAddr != "" {
+		httpListener, err := net.Listen("tcp", cfg.HTTP.ListenAddr)
+		if err != nil {
+			return nil, errors.Errorf("local http listen failed: %w", err)
+		}
+
+		cl.httpListener = httpListener
+	}
+
+	cl.timeout.Store(cfg.Timeout.Duration)
+
+	sess, err := newClientSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	cl.session.Store(sess)
+
+	router, err := newRouter(cfg.Route)
+	if err != nil {
+		return nil, errors.Errorf("load route rules failed: %w", err)
+	}
+
+	cl.router.Store(router)
+
+	if cfg.Metrics != "" {
+		metrics.Handle("GET /status", http.HandlerFunc(cl.serveStatus))
+
+		go func() {
+			if err := metrics.Serve(cfg.Metrics); err != nil {
+				err = errors.Errorf("enable metrics failed: %w", err)
+				log.Warnf("%+v", err)
+			}
+		}()
+	}
+
+	if cfg.Pprof != "" {
+		go func() {
+			if err := http.ListenAndServe(cfg.Pprof, nil); err != nil {
+				err := errors.Errorf("enable pprof failed: %w", err)
+				log.Warnf("%+v", err)
+			}
+		}()
+	}
+
+	return cl, nil
+}
+
+// newClientSession create the session client of all upstream servers.
+func newClientSession(cfg *client.Config) (*clientSession, error) {
+	servers := cfg.UpstreamServers()
+
+	if len(servers) == 1 {
+		sess, err := newSession(servers[0], cfg)
+		if err != nil {
+			return nil, err
+		}
+
+		return newClientSessionFrom(sess), nil
+	}
+
+	var members []upstream.Member
+
+	for _, server := range servers {
+		sess, err := newSession(server, cfg)
+		if err != nil {
+			return nil, errors.Errorf("create upstream %s failed: %w", server.Host, err)
+		}
+
+		members = append(members, upstream.Member{
+			Name:   server.Host,
+			Client: sess,
+			Weight: server.Weight,
+		})
+	}
+
+	var policy upstream.Policy
+
+	switch cfg.Upstream.Policy {
+	case client.PolicyRoundRobin:
+		policy = upstream.RoundRobin
+
+	case client.PolicyLowestLatency:
+		policy = upstream.LowestLatency
+
+	default:
+		policy = upstream.Failover
 	}
 
-	switch cfg.Type {
+	sess := upstream.NewClient(policy, members, upstream.WithCheckInterval(cfg.Upstream.CheckInterval.Duration))
+
+	return newClientSessionFrom(sess), nil
+}
+
+// newSession create the session client of an upstream server.
+func newSession(server client.Server, cfg *client.Config) (session.Client, error) {
+	switch server.Type {
 	case client.TypeWebsocket:
 		var opts []wsslink.Option
 
-		if cfg.DebugCA != "" {
-			ca, err := os.ReadFile(cfg.DebugCA)
+		if server.DebugCA != "" {
+			ca, err := os.ReadFile(server.DebugCA)
 			if err != nil {
-				err = errors.Errorf("read ca cert failed: %w", err)
-				log.Fatalf("%+v", err)
+				return nil, errors.Errorf("read ca cert failed: %w", err)
 			}
 
 			opts = append(opts, wsslink.WithDebugCA(ca))
 		}
 
-		if cfg.Timeout.Duration > 0 {
-			cl.timeout = cfg.Timeout.Duration
+		if server.ClientCrt != "" && server.ClientKey != "" {
+			clientCert, err := tls.LoadX509KeyPair(server.ClientCrt, server.ClientKey)
+			if err != nil {
+				return nil, errors.Errorf("load client certificate failed: %w", err)
+			}
 
+			opts = append(opts, wsslink.WithClientCert(clientCert))
+		}
+
+		if cfg.Timeout.Duration > 0 {
 			opts = append(opts, wsslink.WithHandshakeTimeout(cfg.Timeout.Duration))
 		}
 
+		if server.User != "" {
+			opts = append(opts, wsslink.WithUser(server.User))
+		}
+
+		if cfg.PoolSize > 0 {
+			opts = append(opts, wsslink.WithPoolSize(cfg.PoolSize))
+		}
+
+		opts = append(opts,
+			wsslink.WithBackoff(cfg.Reconnect.BackoffBase.Duration, cfg.Reconnect.BackoffMax.Duration),
+			wsslink.WithBreakerThreshold(cfg.Reconnect.BreakerThreshold),
+		)
+
 		wsURL := (&url.URL{
 			Scheme: "wss",
-			Host:   cfg.Host,
-			Path:   cfg.Path,
+			Host:   server.Host,
+			Path:   server.Path,
 		}).String()
 
-		cl.session = wsslink.NewClient(wsURL, cfg.Secret, cfg.Period, opts...)
+		return wsslink.NewClient(wsURL, server.Secret, server.Period, opts...), nil
 
-		/*case client.TypeQuic:
+	case client.TypeQuic:
 		var opts []quic.Option
 
-		if cfg.DebugCA != "" {
-			ca, err := ioutil.ReadFile(cfg.DebugCA)
+		if server.DebugCA != "" {
+			ca, err := os.ReadFile(server.DebugCA)
 			if err != nil {
-				err = errors.Errorf("read ca cert failed: %w", err)
-				log.Fatalf("%+v", err)
+				return nil, errors.Errorf("read ca cert failed: %w", err)
 			}
 
 			opts = append(opts, quic.WithDebugCA(ca))
 		}
 
-		cl.timeout = cfg.Timeout.Duration
+		if cfg.Timeout.Duration > 0 {
+			opts = append(opts, quic.WithHandshakeTimeout(cfg.Timeout.Duration))
+		}
+
+		if server.User != "" {
+			opts = append(opts, quic.WithUser(server.User))
+		}
+
+		opts = append(opts,
+			quic.WithBackoff(cfg.Reconnect.BackoffBase.Duration, cfg.Reconnect.BackoffMax.Duration),
+			quic.WithBreakerThreshold(cfg.Reconnect.BreakerThreshold),
+		)
 
 		const missingPort = "missing port in address"
 
 		var addrErr *net.AddrError
 
-		if _, _, err := net.SplitHostPort(cfg.Host); err != nil {
+		host := server.Host
+
+		if _, _, err := net.SplitHostPort(host); err != nil {
 			if errors.As(err, &addrErr) && addrErr.Err == missingPort {
-				cfg.Host = net.JoinHostPort(cfg.Host, strconv.Itoa(443))
+				host = net.JoinHostPort(host, strconv.Itoa(443))
 			} else {
 				return nil, errors.Errorf("split quic host failed: %w", err)
 			}
 		}
 
-		cl.session = quic.NewClient(cfg.Host, cfg.Secret, cfg.Period, opts...)*/
+		return quic.NewClient(host, server.Secret, server.Period, opts...), nil
+
+	default:
+		return nil, errors.Errorf("unknown type %s", server.Type)
 	}
+}
 
-	if cfg.Pprof != "" {
-		go func() {
-			if err := http.ListenAndServe(cfg.Pprof, nil); err != nil {
-				err := errors.Errorf("enable pprof failed: %w", err)
-				log.Warnf("%+v", err)
-			}
-		}()
+// serveStatus reply the reconnect status of the upstream servers as JSON.
+func (c *Client) serveStatus(w http.ResponseWriter, _ *http.Request) {
+	statuses := []session.Status{}
+
+	if reporter, ok := c.session.Load().(*clientSession).Client.(session.StatusReporter); ok {
+		statuses = append(statuses, reporter.Status()...)
 	}
 
-	return cl, nil
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		err = errors.Errorf("write status failed: %w", err)
+		log.Debugf("%v", err)
+	}
 }
 
 func (c *Client) Run() {
-	go c.acceptConnReq()
+	go c.serveHTTP(c.mixedHTTPListener)
+
+	c.reloadMutex.Lock()
+
+	if c.httpListener != nil {
+		go c.serveHTTP(c.httpListener)
+	}
+
+	go c.serve(c.listener)
+
+	for _, fl := range c.forwards {
+		go c.serveForward(fl)
+	}
+
+	if c.transparentListener != nil {
+		go c.serveTransparent(c.transparentListener, c.cfg.Transparent.Mode)
+	}
+
+	if c.dns != nil {
+		c.dns.serve()
+	}
+
+	for _, reverse := range c.cfg.Reverse {
+		go c.serveReverse(reverse)
+	}
 
+	c.reloadMutex.Unlock()
+
+	<-c.closeChan
+}
+
+// serve accept socks or mixed conns until listener is closed.
+func (c *Client) serve(listener net.Listener) {
 	for {
-		socksConn, err := c.listener.Accept()
+		socksConn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
 			err = errors.Errorf("accept socks failed: %w", err)
 			log.Errorf("%v", err)
 			continue
@@ -120,122 +380,376 @@ func (c *Client) Run() {
 
 		log.Debugf("accept from %s", socksConn.RemoteAddr())
 
-		go c.handle(socksConn)
+		if c.mixed.Load() {
+			go c.handleMixed(socksConn)
+		} else {
+			go c.handle(socksConn)
+		}
+	}
+}
+
+func (c *Client) serveHTTP(listener net.Listener) {
+	httpServer := &http.Server{Handler: c.httpProxy}
+
+	c.httpServers.Store(httpServer, struct{}{})
+	defer c.httpServers.Delete(httpServer)
+
+	if err := httpServer.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+		err = errors.Errorf("http proxy serve failed: %w", err)
+		log.Errorf("%+v", err)
+	}
+}
+
+const drainCheckInterval = 100 * time.Millisecond
+
+// defaultMaxDials is the default number of session stream opens run at the same time, others
+// wait until a slot is free or the dial timeout, without dial timeout they fail at once.
+const defaultMaxDials = 256
+
+var (
+	errDialQueueFull = errors.New("dial queue is full")
+	errRejected      = errors.New("rejected by route rule")
+)
+
+// dial open a conn of source with preData, the conn is recorded in the access log.
+func (c *Client) dial(ctx context.Context, source string, preData []byte) (net.Conn, error) {
+	return c.dialWith(ctx, source, preData, c.open)
+}
+
+// dialProxy is dial without checking the route rules, the conn is always opened on the session.
+func (c *Client) dialProxy(ctx context.Context, source string, preData []byte) (net.Conn, error) {
+	return c.dialWith(ctx, source, preData, c.openProxy)
+}
+
+func (c *Client) dialWith(ctx context.Context, source string, preData []byte, open func(context.Context, []byte) (net.Conn, error)) (net.Conn, error) {
+	stream := c.accessLog.Start(c.connIdGen.Inc(), source, dialTarget(preData), "")
+
+	conn, err := open(ctx, preData)
+	if err != nil {
+		stream.SetReason("dial failed: " + err.Error())
+		stream.End()
+
+		return nil, err
 	}
+
+	stream.SetUser(sessionUser(conn))
+
+	return stream.Conn(conn), nil
 }
 
-func (c *Client) acceptConnReq() {
-	for connReq := range c.connReqChan {
-		ctx := context.WithValue(connReq.Ctx, session.PreData{}, connReq.Socks.Target())
+// dialTarget return the target of preData for the access log.
+func dialTarget(preData []byte) string {
+	switch {
+	case len(preData) == 0:
+		return ""
+
+	case preData[0] == proto.CmdUDPAssociate:
+		return "udp"
 
-		conn, err := c.session.OpenConn(ctx)
+	case proto.IsAddressType(preData[0]):
+		address, err := libsocks.UnmarshalAddress(preData)
 		if err != nil {
-			err = errors.Errorf("session open connection failed: %w", err)
-			connReq.Err <- err
-			continue
+			return ""
 		}
 
-		connReq.Conn <- conn
+		return address.String()
+
+	default:
+		return fmt.Sprintf("command %d", preData[0])
 	}
 }
 
-func (c *Client) handle(socksConn net.Conn) {
-	socks, err := NewSocks(socksConn)
-	if err != nil {
-		err = errors.Errorf("client handle error: %w", err)
-		log.Errorf("%+v", err)
-		return
+// open open a session conn with preData, when preData is a target address, the route rules are
+// checked first.
+func (c *Client) open(ctx context.Context, preData []byte) (net.Conn, error) {
+	if len(preData) > 0 && proto.IsAddressType(preData[0]) {
+		address, err := libsocks.UnmarshalAddress(preData)
+		if err != nil {
+			return nil, errors.Errorf("unmarshal target failed: %w", err)
+		}
+
+		switch c.router.Load().(*router).route(address) {
+		case actionReject:
+			log.Debugf("reject %s", address)
+
+			return nil, errors.Errorf("dial %s failed: %w", address, errRejected)
+
+		case actionDirect:
+			log.Debugf("direct dial %s", address)
+
+			dialer := net.Dialer{Timeout: c.timeout.Load()}
+
+			conn, err := dialer.DialContext(ctx, "tcp", address.String())
+			if err != nil {
+				return nil, errors.Errorf("direct dial %s failed: %w", address, err)
+			}
+
+			return c.track(conn), nil
+		}
 	}
 
-	var (
-		ctx    context.Context
-		cancel context.CancelFunc
-	)
+	return c.openProxy(ctx, preData)
+}
+
+// openProxy open a session conn with preData, the number of concurrent opens is limited.
+func (c *Client) openProxy(ctx context.Context, preData []byte) (net.Conn, error) {
+	sess := c.acquireSession()
+	defer sess.release()
+
+	return c.openProxyOn(ctx, sess, sess.Client, preData)
+}
+
+// openProxyOn is openProxy by opener, which is sess or one of its upstream servers.
+func (c *Client) openProxyOn(ctx context.Context, sess *clientSession, opener session.Client, preData []byte) (net.Conn, error) {
+	if timeout := c.timeout.Load(); timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	if c.timeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), c.timeout)
-	} else {
-		ctx, cancel = context.WithCancel(context.Background())
+	if err := c.acquireDial(ctx); err != nil {
+		return nil, err
 	}
 
-	defer cancel()
+	defer func() {
+		<-c.dialLimit
+	}()
+
+	conn, err := c.openSessionOn(ctx, sess, opener, preData)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.track(conn), nil
+}
+
+// acquireDial take a dial slot, when all slots are used, wait until the deadline of ctx, or fail
+// fast if ctx has no deadline.
+func (c *Client) acquireDial(ctx context.Context) error {
+	select {
+	case c.dialLimit <- struct{}{}:
+		return nil
 
-	connReq := &connRequest{
-		Socks: socks,
-		Conn:  make(chan net.Conn, 1),
-		Err:   make(chan error, 1),
-		Ctx:   ctx,
+	default:
 	}
 
-	if c.timeout > 0 {
+	if _, ok := ctx.Deadline(); ok {
 		select {
+		case c.dialLimit <- struct{}{}:
+			return nil
+
 		case <-ctx.Done():
-			log.Warn("dial queue is full")
-			_ = socks.Handshake(libsocks.TTLExpired)
-			_ = socks.Close()
-			return
+			// canceled by caller, not because the queue is full
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return ctx.Err()
+			}
+		}
+	}
+
+	log.Warn("dial queue is full")
+	metrics.DialQueueDrops.Inc()
+
+	return errDialQueueFull
+}
+
+// openSession open a stream with preData on the current session, the session is released when
+// the stream is closed.
+func (c *Client) openSession(ctx context.Context, preData []byte) (net.Conn, error) {
+	sess := c.acquireSession()
+	defer sess.release()
 
-		case c.connReqChan <- connReq:
+	return c.openSessionOn(ctx, sess, sess.Client, preData)
+}
+
+// acquireSession acquire the current session, so it won't be closed by reload until released.
+func (c *Client) acquireSession() *clientSession {
+	for {
+		// a session which fails to acquire is retired, reload has stored the new one
+		if sess := c.session.Load().(*clientSession); sess.acquire() {
+			return sess
 		}
-	} else {
+	}
+}
+
+// openSessionOn is openSession by opener, which is sess or one of its upstream servers.
+func (c *Client) openSessionOn(ctx context.Context, sess *clientSession, opener session.Client, preData []byte) (net.Conn, error) {
+	if !sess.acquire() {
+		return nil, errors.New("session is closed")
+	}
+
+	conn, err := opener.OpenConn(context.WithValue(ctx, session.PreData{}, preData))
+	if err != nil {
+		sess.release()
+
+		return nil, errors.Errorf("session open connection failed: %w", err)
+	}
+
+	metrics.StreamsOpened.WithLabelValues(sess.Name()).Inc()
+
+	return sess.track(conn), nil
+}
+
+// track count conn as active until it is closed.
+func (c *Client) track(conn net.Conn) net.Conn {
+	c.active.Inc()
+
+	return newTrackedConn(conn, func() {
+		c.active.Dec()
+	})
+}
+
+// Shutdown stop accepting new conns, wait for the active conns until they are closed or ctx is
+// done, then close the session.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.reloadMutex.Lock()
+	defer c.reloadMutex.Unlock()
+
+	if !c.closed.CAS(false, true) {
+		return nil
+	}
+
+	_ = c.listener.Close()
+	_ = c.mixedHTTPListener.Close()
+
+	if c.httpListener != nil {
+		_ = c.httpListener.Close()
+	}
+
+	for _, fl := range c.forwards {
+		_ = fl.Close()
+	}
+
+	if c.transparentListener != nil {
+		_ = c.transparentListener.Close()
+	}
+
+	if c.dns != nil {
+		c.dns.close()
+	}
+
+	c.closeReverse()
+
+	// http servers stop serving keep-alive conns when their requests are done
+	c.httpServers.Range(func(key, _ interface{}) bool {
+		go func(httpServer *http.Server) {
+			_ = httpServer.Shutdown(ctx)
+		}(key.(*http.Server))
+
+		return true
+	})
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+drain:
+	for c.active.Load() > 0 {
+		// idle conns of the forward proxy transport are not in use
+		c.httpProxy.transport.CloseIdleConnections()
+
 		select {
-		default:
-			log.Warn("dial queue is full")
-			_ = socks.Handshake(libsocks.TTLExpired)
-			_ = socks.Close()
-			return
+		case <-ctx.Done():
+			log.Warnf("drain timeout, close %d conns", c.active.Load())
+
+			c.httpServers.Range(func(key, _ interface{}) bool {
+				_ = key.(*http.Server).Close()
 
-		case c.connReqChan <- connReq:
+				return true
+			})
+
+			break drain
+
+		case <-ticker.C:
 		}
 	}
 
-	var sessionConn net.Conn
+	err := c.session.Load().(*clientSession).Close()
 
-	select {
-	case <-time.After(30 * time.Second):
-		log.Error("client handle timeout")
+	_ = c.accessLog.Close()
 
-		_ = socks.Handshake(libsocks.TTLExpired)
-		_ = socks.Close()
+	close(c.closeChan)
 
+	return err
+}
+
+// Close close the session immediately.
+func (c *Client) Close() error {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return c.Shutdown(ctx)
+}
+
+func (c *Client) handle(socksConn net.Conn) {
+	socks, err := NewSocks(socksConn)
+	if err != nil {
+		err = errors.Errorf("client handle error: %w", err)
+		log.Errorf("%+v", err)
 		return
+	}
+
+	preData := socks.Target()
+	if socks.IsUDPAssociate() {
+		preData = []byte{proto.CmdUDPAssociate}
+	}
 
-	case err := <-connReq.Err:
-		log.Errorf("client handle error: %+v", err)
+	sessionConn, err := c.dial(context.Background(), socks.RemoteAddr().String(), preData)
+	if err != nil {
+		if !errors.Is(err, errDialQueueFull) && !errors.Is(err, errRejected) {
+			log.Errorf("client handle error: %+v", err)
+		}
 
 		var netErr net.Error
-		if errors.As(err, &netErr) && netErr.Timeout() {
+		switch {
+		case errors.Is(err, errRejected):
+			_ = socks.Handshake(libsocks.ConnNotAllowed)
+
+		case errors.Is(err, errDialQueueFull) || errors.As(err, &netErr) && netErr.Timeout():
 			_ = socks.Handshake(libsocks.TTLExpired)
-		} else {
+
+		default:
 			_ = socks.Handshake(libsocks.ServerFailed)
 		}
 
 		_ = socks.Close()
 		return
+	}
 
-	case sessionConn = <-connReq.Conn:
-		log.Debug("start socks handshake")
+	if socks.IsUDPAssociate() {
+		c.handleUDP(socks, sessionConn)
+		return
+	}
 
-		if err := socks.Handshake(libsocks.Success); err != nil {
-			err := errors.Errorf("client handle error: %w", err)
-			log.Errorf("%+v", err)
-			_ = socks.Close()
-			_ = sessionConn.Close()
-			return
-		}
+	log.Debug("start socks handshake")
 
-		log.Debug("socks handshake success")
+	if err := socks.Handshake(libsocks.Success); err != nil {
+		err := errors.Errorf("client handle error: %w", err)
+		log.Errorf("%+v", err)
+		_ = socks.Close()
+		_ = sessionConn.Close()
+		return
 	}
 
+	log.Debug("socks handshake success")
+
+	relay(socks, sessionConn)
+}
+
+// relay copy data between local conn and session conn, close both when any direction is done.
+func relay(local, sessionConn net.Conn) {
 	go func() {
-		_, _ = io.Copy(sessionConn, socks)
-		_ = socks.Close()
+		_, err := io.Copy(metrics.CountWriter(sessionConn, metrics.DirectionOut), local)
+		accesslog.SetReason(sessionConn, accesslog.Reason("client", err))
+
+		_ = local.Close()
 		_ = sessionConn.Close()
 	}()
 
 	go func() {
-		_, _ = io.Copy(socks, sessionConn)
-		_ = socks.Close()
+		_, err := io.Copy(metrics.CountWriter(local, metrics.DirectionIn), sessionConn)
+		accesslog.SetReason(sessionConn, accesslog.Reason("target", err))
+
+		_ = local.Close()
 		_ = sessionConn.Close()
 	}()
 }
@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/config/client"
+	"github.com/Sherlock-Holo/camouflage/proto"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	errors "golang.org/x/xerrors"
+)
+
+const dnsTimeout = 5 * time.Second
+
+// dnsLocal resolve domains and their subdomains by upstream directly.
+type dnsLocal struct {
+	domains  []string // lower case fqdn
+	upstream string
+}
+
+func newDNSLocals(cfg []client.DNSLocal) []dnsLocal {
+	locals := make([]dnsLocal, 0, len(cfg))
+
+	for _, localCfg := range cfg {
+		local := dnsLocal{upstream: localCfg.Upstream}
+
+		for _, domain := range localCfg.Domains {
+			local.domains = append(local.domains, strings.ToLower(dns.Fqdn(domain)))
+		}
+
+		locals = append(locals, local)
+	}
+
+	return locals
+}
+
+func (l dnsLocal) match(name string) bool {
+	for _, domain := range l.domains {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dnsServer serve DNS over UDP and TCP, the queries are resolved by server through the session,
+// except the domains of the local rules.
+type dnsServer struct {
+	client  *Client
+	servers []*dns.Server
+	cache   *dnsCache
+
+	locals atomic.Value // []dnsLocal
+}
+
+func (c *Client) newDNSServer(cfg client.DNS) (*dnsServer, error) {
+	packetConn, err := net.ListenPacket("udp", cfg.ListenAddr)
+	if err != nil {
+		return nil, errors.Errorf("dns listen udp failed: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", cfg.ListenAddr)
+	if err != nil {
+		_ = packetConn.Close()
+
+		return nil, errors.Errorf("dns listen tcp failed: %w", err)
+	}
+
+	d := &dnsServer{
+		client: c,
+		cache:  newDNSCache(cfg.CacheSize),
+	}
+
+	d.servers = []*dns.Server{
+		{PacketConn: packetConn, Handler: d},
+		{Listener: listener, Handler: d},
+	}
+
+	d.locals.Store(newDNSLocals(cfg.Local))
+
+	return d, nil
+}
+
+// serve serve the UDP and TCP DNS servers until they are closed.
+func (d *dnsServer) serve() {
+	for _, server := range d.servers {
+		go func(server *dns.Server) {
+			if err := server.ActivateAndServe(); err != nil && !errors.Is(err, net.ErrClosed) {
+				err = errors.Errorf("dns serve failed: %w", err)
+				log.Errorf("%+v", err)
+			}
+		}(server)
+	}
+}
+
+func (d *dnsServer) close() {
+	for _, server := range d.servers {
+		_ = server.Shutdown()
+	}
+}
+
+func (d *dnsServer) ServeDNS(w dns.ResponseWriter, query *dns.Msg) {
+	if len(query.Question) != 1 {
+		_ = w.WriteMsg(new(dns.Msg).SetRcode(query, dns.RcodeFormatError))
+
+		return
+	}
+
+	question := query.Question[0]
+
+	answer := d.cache.get(question)
+	if answer == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+		defer cancel()
+
+		var err error
+
+		answer, err = d.exchange(ctx, query)
+		if err != nil {
+			err = errors.Errorf("dns query %s failed: %w", question.String(), err)
+			log.Warnf("%v", err)
+
+			answer = new(dns.Msg).SetRcode(query, dns.RcodeServerFailure)
+		} else {
+			d.cache.set(question, answer)
+		}
+	}
+
+	answer.Id = query.Id
+
+	// UDP client can't receive an answer larger than its buffer, it retry over TCP
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := query.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+
+		answer.Truncate(size)
+	}
+
+	if err := w.WriteMsg(answer); err != nil {
+		err = errors.Errorf("write dns answer failed: %w", err)
+		log.Debugf("%v", err)
+	}
+}
+
+// exchange resolve query by the local upstream of its domain, or by server.
+func (d *dnsServer) exchange(ctx context.Context, query *dns.Msg) (*dns.Msg, error) {
+	name := strings.ToLower(dns.Fqdn(query.Question[0].Name))
+
+	for _, local := range d.locals.Load().([]dnsLocal) {
+		if local.match(name) {
+			return exchangeUpstream(ctx, query, local.upstream)
+		}
+	}
+
+	return d.exchangeTunnel(ctx, query)
+}
+
+// exchangeTunnel send query to server on a DNS stream.
+func (d *dnsServer) exchangeTunnel(ctx context.Context, query *dns.Msg) (*dns.Msg, error) {
+	conn, err := d.client.openProxy(ctx, []byte{proto.CmdDNS})
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	dnsConn := &dns.Conn{Conn: conn}
+
+	if err := dnsConn.WriteMsg(query); err != nil {
+		return nil, errors.Errorf("write dns query failed: %w", err)
+	}
+
+	answer, err := dnsConn.ReadMsg()
+	if err != nil {
+		return nil, errors.Errorf("read dns answer failed: %w", err)
+	}
+
+	return answer, nil
+}
+
+// exchangeUpstream send query to upstream over UDP, a truncated answer is retried over TCP.
+func exchangeUpstream(ctx context.Context, query *dns.Msg, upstream string) (*dns.Msg, error) {
+	answer, _, err := new(dns.Client).ExchangeContext(ctx, query, upstream)
+	if err == nil && answer.Truncated {
+		answer, _, err = (&dns.Client{Net: "tcp"}).ExchangeContext(ctx, query, upstream)
+	}
+
+	if err != nil {
+		return nil, errors.Errorf("exchange dns with %s failed: %w", upstream, err)
+	}
+
+	return answer, nil
+}
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const defaultDNSCacheSize = 4096
+
+type dnsCacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type dnsCacheEntry struct {
+	answer *dns.Msg
+	stored time.Time
+	expire time.Time
+}
+
+// dnsCache cache the answers by their TTL, when it is full, expired answers are removed first,
+// then a random one.
+type dnsCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[dnsCacheKey]dnsCacheEntry
+}
+
+func newDNSCache(size int) *dnsCache {
+	if size <= 0 {
+		size = defaultDNSCacheSize
+	}
+
+	return &dnsCache{
+		size:    size,
+		entries: make(map[dnsCacheKey]dnsCacheEntry),
+	}
+}
+
+func newDNSCacheKey(question dns.Question) dnsCacheKey {
+	return dnsCacheKey{
+		name:   strings.ToLower(question.Name),
+		qtype:  question.Qtype,
+		qclass: question.Qclass,
+	}
+}
+
+// get return a copy of the cached answer of question, its TTLs are decreased by the cached time.
+func (c *dnsCache) get(question dns.Question) *dns.Msg {
+	key := newDNSCacheKey(question)
+
+	c.mutex.Lock()
+	entry, ok := c.entries[key]
+	c.mutex.Unlock()
+
+	now := time.Now()
+
+	if !ok || !now.Before(entry.expire) {
+		return nil
+	}
+
+	answer := entry.answer.Copy()
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+
+	for _, rrs := range [][]dns.RR{answer.Answer, answer.Ns, answer.Extra} {
+		for _, rr := range rrs {
+			// OPT record use TTL as flags
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+
+			if rr.Header().Ttl > elapsed {
+				rr.Header().Ttl -= elapsed
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+
+	return answer
+}
+
+// set cache answer of question if it can be cached.
+func (c *dnsCache) set(question dns.Question, answer *dns.Msg) {
+	ttl, ok := dnsTTL(answer)
+	if !ok || ttl == 0 {
+		return
+	}
+
+	now := time.Now()
+
+	entry := dnsCacheEntry{
+		answer: answer.Copy(),
+		stored: now,
+		expire: now.Add(time.Duration(ttl) * time.Second),
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.entries) >= c.size {
+		for key, old := range c.entries {
+			if !now.Before(old.expire) {
+				delete(c.entries, key)
+			}
+		}
+
+		for key := range c.entries {
+			if len(c.entries) < c.size {
+				break
+			}
+
+			delete(c.entries, key)
+		}
+	}
+
+	c.entries[newDNSCacheKey(question)] = entry
+}
+
+// dnsTTL return how long answer can be cached, the min TTL of the answer records, or of the SOA
+// record for a negative answer.
+func dnsTTL(answer *dns.Msg) (uint32, bool) {
+	if answer.Truncated {
+		return 0, false
+	}
+
+	switch answer.Rcode {
+	case dns.RcodeSuccess:
+		if len(answer.Answer) > 0 {
+			ttl := answer.Answer[0].Header().Ttl
+
+			for _, rr := range answer.Answer[1:] {
+				ttl = min(ttl, rr.Header().Ttl)
+			}
+
+			return ttl, true
+		}
+
+	case dns.RcodeNameError:
+
+	default:
+		return 0, false
+	}
+
+	for _, rr := range answer.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return min(soa.Hdr.Ttl, soa.Minttl), true
+		}
+	}
+
+	return 0, false
+}
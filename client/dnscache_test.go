@@ -0,0 +1,151 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func newTestAnswer(rcode int, answerTTLs []uint32, soaTTL, minTTL uint32) *dns.Msg {
+	query := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+
+	answer := new(dns.Msg).SetRcode(query, rcode)
+
+	for _, ttl := range answerTTLs {
+		answer.Answer = append(answer.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl},
+			A:   net.IPv4(93, 184, 216, 34),
+		})
+	}
+
+	if soaTTL > 0 {
+		answer.Ns = append(answer.Ns, &dns.SOA{
+			Hdr:    dns.RR_Header{Name: "com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: soaTTL},
+			Ns:     "a.gtld-servers.net.",
+			Mbox:   "nstld.verisign-grs.com.",
+			Minttl: minTTL,
+		})
+	}
+
+	return answer
+}
+
+func TestDNSTTL(t *testing.T) {
+	truncated := newTestAnswer(dns.RcodeSuccess, []uint32{300}, 0, 0)
+	truncated.Truncated = true
+
+	tests := []struct {
+		name   string
+		answer *dns.Msg
+		ttl    uint32
+		ok     bool
+	}{
+		{"single answer", newTestAnswer(dns.RcodeSuccess, []uint32{300}, 0, 0), 300, true},
+		{"min answer ttl", newTestAnswer(dns.RcodeSuccess, []uint32{300, 60, 120}, 0, 0), 60, true},
+		{"zero ttl", newTestAnswer(dns.RcodeSuccess, []uint32{0}, 0, 0), 0, true},
+		{"nxdomain by soa ttl", newTestAnswer(dns.RcodeNameError, nil, 900, 3600), 900, true},
+		{"nxdomain by soa minimum", newTestAnswer(dns.RcodeNameError, nil, 3600, 900), 900, true},
+		{"nodata by soa", newTestAnswer(dns.RcodeSuccess, nil, 600, 600), 600, true},
+		{"nxdomain without soa", newTestAnswer(dns.RcodeNameError, nil, 0, 0), 0, false},
+		{"nodata without soa", newTestAnswer(dns.RcodeSuccess, nil, 0, 0), 0, false},
+		{"server failure", newTestAnswer(dns.RcodeServerFailure, nil, 600, 600), 0, false},
+		{"truncated", truncated, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl, ok := dnsTTL(tt.answer)
+			if ttl != tt.ttl || ok != tt.ok {
+				t.Errorf("dnsTTL = %d, %v, want %d, %v", ttl, ok, tt.ttl, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDNSCacheExpire(t *testing.T) {
+	question := dns.Question{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	tests := []struct {
+		name    string
+		answer  *dns.Msg
+		elapsed time.Duration
+		cached  bool
+		ttl     uint32
+	}{
+		{"fresh", newTestAnswer(dns.RcodeSuccess, []uint32{300}, 0, 0), 0, true, 300},
+		{"ttl decreased", newTestAnswer(dns.RcodeSuccess, []uint32{300}, 0, 0), 100 * time.Second, true, 200},
+		{"min ttl decides expiry", newTestAnswer(dns.RcodeSuccess, []uint32{300, 60}, 0, 0), 90 * time.Second, false, 0},
+		{"expired", newTestAnswer(dns.RcodeSuccess, []uint32{300}, 0, 0), 300 * time.Second, false, 0},
+		{"zero ttl is not cached", newTestAnswer(dns.RcodeSuccess, []uint32{0}, 0, 0), 0, false, 0},
+		{"negative answer", newTestAnswer(dns.RcodeNameError, nil, 600, 60), 30 * time.Second, true, 0},
+		{"negative answer expired", newTestAnswer(dns.RcodeNameError, nil, 600, 60), 60 * time.Second, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newDNSCache(0)
+			cache.set(question, tt.answer)
+
+			// move the entry back in time instead of sleeping
+			key := newDNSCacheKey(question)
+			if entry, ok := cache.entries[key]; ok {
+				entry.stored = entry.stored.Add(-tt.elapsed)
+				entry.expire = entry.expire.Add(-tt.elapsed)
+				cache.entries[key] = entry
+			}
+
+			cached := cache.get(question)
+			if (cached != nil) != tt.cached {
+				t.Fatalf("cached = %v, want %v", cached != nil, tt.cached)
+			}
+
+			if cached != nil && len(cached.Answer) > 0 {
+				if ttl := cached.Answer[0].Header().Ttl; ttl != tt.ttl {
+					t.Errorf("ttl = %d, want %d", ttl, tt.ttl)
+				}
+			}
+		})
+	}
+}
+
+func TestDNSCacheGetCopy(t *testing.T) {
+	question := dns.Question{Name: "Example.COM.", Qtype: dns.TypeA, Qclass: dns.ClassINET}
+
+	cache := newDNSCache(0)
+	cache.set(question, newTestAnswer(dns.RcodeSuccess, []uint32{300}, 0, 0))
+
+	// the name is case-insensitive
+	first := cache.get(dns.Question{Name: "example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	if first == nil {
+		t.Fatal("answer is not cached")
+	}
+
+	first.Answer[0].Header().Ttl = 1
+
+	if second := cache.get(question); second.Answer[0].Header().Ttl != 300 {
+		t.Errorf("cached answer is changed by caller, ttl = %d", second.Answer[0].Header().Ttl)
+	}
+
+	if cache.get(dns.Question{Name: "example.com.", Qtype: dns.TypeAAAA, Qclass: dns.ClassINET}) != nil {
+		t.Error("answer of other qtype is returned")
+	}
+}
+
+func TestDNSCacheSize(t *testing.T) {
+	cache := newDNSCache(2)
+
+	for _, name := range []string{"a.example.", "b.example.", "c.example."} {
+		cache.set(dns.Question{Name: name, Qtype: dns.TypeA, Qclass: dns.ClassINET},
+			newTestAnswer(dns.RcodeSuccess, []uint32{300}, 0, 0))
+	}
+
+	if len(cache.entries) != 2 {
+		t.Errorf("cache has %d entries, want 2", len(cache.entries))
+	}
+
+	if cache.get(dns.Question{Name: "c.example.", Qtype: dns.TypeA, Qclass: dns.ClassINET}) == nil {
+		t.Error("the latest answer is evicted")
+	}
+}
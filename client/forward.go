@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	"github.com/Sherlock-Holo/camouflage/config/client"
+	"github.com/Sherlock-Holo/camouflage/proto"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	errors "golang.org/x/xerrors"
+)
+
+// forwardListener accept the conns of a static forward, the target can be replaced by reload
+// without rebinding.
+type forwardListener struct {
+	net.Listener
+
+	target atomic.Value // []byte, socks address
+}
+
+func forwardTarget(forward client.Forward) ([]byte, error) {
+	address, err := proto.ParseAddress(forward.Target)
+	if err != nil {
+		return nil, errors.Errorf("parse forward target %s failed: %w", forward.Target, err)
+	}
+
+	return address.Bytes(), nil
+}
+
+// listenForwards listen the forwards which are not listened yet, if any of them fails, the new
+// listeners are closed.
+func (c *Client) listenForwards(forwards []client.Forward) (map[string]*forwardListener, error) {
+	added := make(map[string]*forwardListener)
+
+	closeAdded := func() {
+		for _, fl := range added {
+			_ = fl.Close()
+		}
+	}
+
+	for _, forward := range forwards {
+		target, err := forwardTarget(forward)
+		if err != nil {
+			closeAdded()
+
+			return nil, err
+		}
+
+		if _, ok := c.forwards[forward.ListenAddr]; ok {
+			continue
+		}
+
+		if _, ok := added[forward.ListenAddr]; ok {
+			closeAdded()
+
+			return nil, errors.Errorf("duplicate forward listen addr %s", forward.ListenAddr)
+		}
+
+		listener, err := net.Listen("tcp", forward.ListenAddr)
+		if err != nil {
+			closeAdded()
+
+			return nil, errors.Errorf("forward listen %s failed: %w", forward.ListenAddr, err)
+		}
+
+		fl := &forwardListener{Listener: listener}
+		fl.target.Store(target)
+
+		added[forward.ListenAddr] = fl
+	}
+
+	return added, nil
+}
+
+// applyForwards start the added listeners, close the removed ones and update the targets.
+func (c *Client) applyForwards(forwards []client.Forward, added map[string]*forwardListener) {
+	for addr, fl := range added {
+		c.forwards[addr] = fl
+
+		go c.serveForward(fl)
+
+		log.Infof("start forward %s", addr)
+	}
+
+	kept := make(map[string]bool, len(forwards))
+
+	for _, forward := range forwards {
+		kept[forward.ListenAddr] = true
+
+		// validated by listenForwards
+		target, _ := forwardTarget(forward)
+		c.forwards[forward.ListenAddr].target.Store(target)
+	}
+
+	for addr, fl := range c.forwards {
+		if !kept[addr] {
+			_ = fl.Close()
+			delete(c.forwards, addr)
+
+			log.Infof("stop forward %s", addr)
+		}
+	}
+}
+
+// serveForward accept conns of fl until it is closed.
+func (c *Client) serveForward(fl *forwardListener) {
+	for {
+		conn, err := fl.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			err = errors.Errorf("accept forward failed: %w", err)
+			log.Errorf("%v", err)
+			continue
+		}
+
+		go c.handleForward(conn, fl.target.Load().([]byte))
+	}
+}
+
+// handleForward proxy conn to target without socks handshake, route rules are not checked.
+func (c *Client) handleForward(conn net.Conn, target []byte) {
+	sessionConn, err := c.dialProxy(context.Background(), conn.RemoteAddr().String(), target)
+	if err != nil {
+		if !errors.Is(err, errDialQueueFull) {
+			log.Errorf("client handle forward error: %+v", err)
+		}
+
+		_ = conn.Close()
+		return
+	}
+
+	relay(conn, sessionConn)
+}
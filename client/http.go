@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/proto"
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+// hopHeaders are removed when forward a request, see RFC 7230 section 6.1.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// sourceKey is the context key of the client addr of a forward request, the transport dials
+// with it.
+type sourceKey struct{}
+
+// httpProxy is a HTTP proxy, support CONNECT tunnel and absolute-URI forward request.
+type httpProxy struct {
+	client    *Client
+	transport *http.Transport
+}
+
+func newHTTPProxy(c *Client) *httpProxy {
+	hp := &httpProxy{client: c}
+
+	hp.transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			address, err := proto.ParseAddress(addr)
+			if err != nil {
+				return nil, errors.Errorf("parse target %s failed: %w", addr, err)
+			}
+
+			source, _ := ctx.Value(sourceKey{}).(string)
+
+			return c.dial(ctx, source, address.Bytes())
+		},
+		MaxIdleConns:    100,
+		IdleConnTimeout: 90 * time.Second,
+	}
+
+	return hp
+}
+
+func (h *httpProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("http proxy %s %s from %s", r.Method, r.RequestURI, r.RemoteAddr)
+
+	if r.Method == http.MethodConnect {
+		h.handleConnect(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+
+	h.handleForward(w, r)
+}
+
+func (h *httpProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
+	address, err := proto.ParseAddress(r.Host)
+	if err != nil {
+		http.Error(w, "invalid target", http.StatusBadRequest)
+		return
+	}
+
+	sessionConn, err := h.client.dial(r.Context(), r.RemoteAddr, address.Bytes())
+	if err != nil {
+		h.writeDialError(w, err)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = sessionConn.Close()
+		http.Error(w, "hijack not support", http.StatusInternalServerError)
+		return
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		err = errors.Errorf("http proxy hijack failed: %w", err)
+		log.Errorf("%+v", err)
+		_ = sessionConn.Close()
+		return
+	}
+
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		_ = conn.Close()
+		_ = sessionConn.Close()
+		return
+	}
+
+	// client may send data before receive the response
+	if n := rw.Reader.Buffered(); n > 0 {
+		buffered, _ := rw.Reader.Peek(n)
+		if _, err := sessionConn.Write(buffered); err != nil {
+			_ = conn.Close()
+			_ = sessionConn.Close()
+			return
+		}
+	}
+
+	relay(conn, sessionConn)
+}
+
+func (h *httpProxy) handleForward(w http.ResponseWriter, r *http.Request) {
+	outReq := r.Clone(context.WithValue(r.Context(), sourceKey{}, r.RemoteAddr))
+	outReq.RequestURI = ""
+	removeHopHeaders(outReq.Header)
+
+	resp, err := h.transport.RoundTrip(outReq)
+	if err != nil {
+		h.writeDialError(w, err)
+		return
+	}
+
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	removeHopHeaders(resp.Header)
+	copyHeader(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		err = errors.Errorf("http proxy copy response failed: %w", err)
+		log.Debugf("%v", err)
+	}
+}
+
+func (h *httpProxy) writeDialError(w http.ResponseWriter, err error) {
+	if !errors.Is(err, errDialQueueFull) && !errors.Is(err, errRejected) {
+		log.Errorf("client handle error: %+v", err)
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errRejected):
+		http.Error(w, "forbidden", http.StatusForbidden)
+
+	case errors.Is(err, errDialQueueFull) || errors.As(err, &netErr) && netErr.Timeout():
+		http.Error(w, "gateway timeout", http.StatusGatewayTimeout)
+
+	default:
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}
+}
+
+func removeHopHeaders(header http.Header) {
+	for _, field := range header.Values("Connection") {
+		for _, name := range strings.Split(field, ",") {
+			header.Del(strings.TrimSpace(name))
+		}
+	}
+
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vv := range src {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+}
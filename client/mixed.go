@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+const sniffTimeout = 10 * time.Second
+
+// peekConn is a net.Conn which has been peeked, the peeked data will be read first.
+type peekConn struct {
+	net.Conn
+
+	reader *bufio.Reader
+}
+
+func (p *peekConn) Read(b []byte) (int, error) {
+	return p.reader.Read(b)
+}
+
+// handleMixed sniff the first byte of conn and dispatch it to socks5, socks4 or HTTP proxy.
+func (c *Client) handleMixed(conn net.Conn) {
+	pc := &peekConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(sniffTimeout))
+
+	head, err := pc.reader.Peek(1)
+	if err != nil {
+		err = errors.Errorf("sniff %s failed: %w", conn.RemoteAddr(), err)
+		log.Debugf("%v", err)
+		_ = conn.Close()
+		return
+	}
+
+	_ = conn.SetReadDeadline(time.Time{})
+
+	switch head[0] {
+	case 5:
+		c.handle(pc)
+
+	case socks4Version:
+		c.handleSocks4(pc)
+
+	default:
+		c.mixedHTTPListener.push(pc)
+	}
+}
+
+// chanListener is a net.Listener, accept the conns pushed into it.
+type chanListener struct {
+	addr      net.Addr
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newChanListener(addr net.Addr) *chanListener {
+	return &chanListener{
+		addr:   addr,
+		conns:  make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+}
+
+func (l *chanListener) push(conn net.Conn) {
+	select {
+	case <-l.closed:
+		_ = conn.Close()
+
+	case l.conns <- conn:
+	}
+}
+
+func (l *chanListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.closed:
+		return nil, &net.OpError{
+			Op:  "accept",
+			Net: l.addr.Network(),
+			Err: net.ErrClosed,
+		}
+
+	case conn := <-l.conns:
+		return conn, nil
+	}
+}
+
+func (l *chanListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+	})
+
+	return nil
+}
+
+func (l *chanListener) Addr() net.Addr {
+	return l.addr
+}
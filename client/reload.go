@@ -0,0 +1,269 @@
+package client
+
+import (
+	"net"
+	"slices"
+	"sync"
+
+	"github.com/Sherlock-Holo/camouflage/config/client"
+	"github.com/Sherlock-Holo/camouflage/session"
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+// clientSession count the streams opened on the session, after it is replaced by reload, it is
+// closed when the last stream is closed, so existing streams are not interrupted.
+type clientSession struct {
+	session.Client
+
+	// streams, retired and closed are guarded by mutex
+	mutex   sync.Mutex
+	streams int
+	retired bool
+	closed  bool
+}
+
+func newClientSessionFrom(sess session.Client) *clientSession {
+	return &clientSession{Client: sess}
+}
+
+// acquire count a stream, it fails when the session is retired and closed, the caller should use
+// the current session instead.
+func (s *clientSession) acquire() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return false
+	}
+
+	s.streams++
+
+	return true
+}
+
+func (s *clientSession) release() {
+	s.mutex.Lock()
+
+	s.streams--
+	closeNow := s.streams == 0 && s.retired && !s.closed
+	s.closed = s.closed || closeNow
+
+	s.mutex.Unlock()
+
+	if closeNow {
+		_ = s.Close()
+	}
+}
+
+// retire close the session when there is no stream on it.
+func (s *clientSession) retire() {
+	s.mutex.Lock()
+
+	s.retired = true
+	closeNow := s.streams == 0 && !s.closed
+	s.closed = s.closed || closeNow
+
+	s.mutex.Unlock()
+
+	if closeNow {
+		_ = s.Close()
+	}
+}
+
+// track release the session when conn is closed.
+func (s *clientSession) track(conn net.Conn) net.Conn {
+	return newTrackedConn(conn, s.release)
+}
+
+// sessionUser return the user which the session conn is authenticated as, conn may be wrapped
+// by trackedConn.
+func sessionUser(conn net.Conn) string {
+	return session.User(unwrapTracked(conn))
+}
+
+// sessionOpener return the client which opened the session conn, conn may be wrapped by
+// trackedConn.
+func sessionOpener(conn net.Conn) (session.Client, bool) {
+	return session.Opener(unwrapTracked(conn))
+}
+
+func unwrapTracked(conn net.Conn) net.Conn {
+	for {
+		tc, ok := conn.(*trackedConn)
+		if !ok {
+			return conn
+		}
+
+		conn = tc.Conn
+	}
+}
+
+// trackedConn call onClose once when it is closed.
+type trackedConn struct {
+	net.Conn
+
+	closeOnce sync.Once
+	onClose   func()
+}
+
+func newTrackedConn(conn net.Conn, onClose func()) *trackedConn {
+	return &trackedConn{
+		Conn:    conn,
+		onClose: onClose,
+	}
+}
+
+func (t *trackedConn) Close() error {
+	t.closeOnce.Do(t.onClose)
+
+	return t.Conn.Close()
+}
+
+// sessionChanged report if the settings used to create the session are changed.
+func sessionChanged(old, cfg *client.Config) bool {
+	return !slices.Equal(old.UpstreamServers(), cfg.UpstreamServers()) ||
+		old.Upstream != cfg.Upstream ||
+		old.Reconnect != cfg.Reconnect ||
+		old.Timeout != cfg.Timeout ||
+		old.PoolSize != cfg.PoolSize
+}
+
+// Reload apply cfg, listeners are rebound only if the address changed, route rules, timeout,
+// forwards and dns local rules are swapped. The session is replaced only if the upstream
+// settings changed, the old session keeps running until its streams are closed. If any part
+// of cfg fails, nothing is changed.
+func (c *Client) Reload(cfg *client.Config) error {
+	router, err := newRouter(cfg.Route)
+	if err != nil {
+		return errors.Errorf("load route rules failed: %w", err)
+	}
+
+	c.reloadMutex.Lock()
+	defer c.reloadMutex.Unlock()
+
+	if c.closed.Load() {
+		return errors.New("client is closed")
+	}
+
+	var (
+		listener     net.Listener
+		httpListener net.Listener
+	)
+
+	closeListeners := func() {
+		if listener != nil {
+			_ = listener.Close()
+		}
+
+		if httpListener != nil {
+			_ = httpListener.Close()
+		}
+	}
+
+	if cfg.ListenAddr != c.cfg.ListenAddr {
+		listener, err = net.Listen("tcp", cfg.ListenAddr)
+		if err != nil {
+			return errors.Errorf("local listen failed: %w", err)
+		}
+	}
+
+	if cfg.HTTP.ListenAddr != c.cfg.HTTP.ListenAddr && cfg.HTTP.ListenAddr != "" {
+		httpListener, err = net.Listen("tcp", cfg.HTTP.ListenAddr)
+		if err != nil {
+			closeListeners()
+
+			return errors.Errorf("local http listen failed: %w", err)
+		}
+	}
+
+	addedForwards, err := c.listenForwards(cfg.Forward)
+	if err != nil {
+		closeListeners()
+
+		return err
+	}
+
+	var sess *clientSession
+
+	if sessionChanged(&c.cfg, cfg) {
+		sess, err = newClientSession(cfg)
+		if err != nil {
+			closeListeners()
+
+			for _, fl := range addedForwards {
+				_ = fl.Close()
+			}
+
+			return err
+		}
+	}
+
+	if listener != nil {
+		_ = c.listener.Close()
+		c.listener = listener
+
+		go c.serve(listener)
+
+		log.Infof("rebind listener to %s", cfg.ListenAddr)
+	}
+
+	if cfg.HTTP.ListenAddr != c.cfg.HTTP.ListenAddr {
+		if c.httpListener != nil {
+			_ = c.httpListener.Close()
+		}
+
+		c.httpListener = httpListener
+
+		if httpListener != nil {
+			go c.serveHTTP(httpListener)
+
+			log.Infof("rebind http listener to %s", cfg.HTTP.ListenAddr)
+		}
+	}
+
+	c.applyForwards(cfg.Forward, addedForwards)
+
+	c.mixed.Store(cfg.Mixed)
+	c.timeout.Store(cfg.Timeout.Duration)
+	c.router.Store(router)
+
+	if sess != nil {
+		old := c.session.Swap(sess).(*clientSession)
+		old.retire()
+
+		log.Info("upstream settings changed, replace session")
+	}
+
+	if !slices.Equal(cfg.Reverse, c.cfg.Reverse) {
+		log.Warn("reverse tunnels change need restart")
+	}
+
+	if c.dns != nil {
+		c.dns.locals.Store(newDNSLocals(cfg.DNS.Local))
+	}
+
+	if cfg.DNS.ListenAddr != c.cfg.DNS.ListenAddr || cfg.DNS.CacheSize != c.cfg.DNS.CacheSize {
+		log.Warn("dns listen addr and cache size change need restart")
+	}
+
+	if cfg.MaxDials != c.cfg.MaxDials {
+		log.Warn("max dials change need restart")
+	}
+
+	if cfg.Transparent != c.cfg.Transparent {
+		log.Warn("transparent proxy change need restart")
+	}
+
+	if cfg.AccessLog != c.cfg.AccessLog {
+		log.Warn("access log change need restart")
+	}
+
+	if cfg.Metrics != c.cfg.Metrics || cfg.Pprof != c.cfg.Pprof {
+		log.Warn("metrics and pprof listen addr change need restart")
+	}
+
+	c.cfg = *cfg
+
+	return nil
+}
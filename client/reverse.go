@@ -0,0 +1,183 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/accesslog"
+	"github.com/Sherlock-Holo/camouflage/config/client"
+	"github.com/Sherlock-Holo/camouflage/metrics"
+	"github.com/Sherlock-Holo/camouflage/proto"
+	"github.com/Sherlock-Holo/camouflage/session"
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+const (
+	reverseRetryMin = time.Second
+	reverseRetryMax = 30 * time.Second
+)
+
+// serveReverse keep the reverse tunnel registered on server until client is closed, when the
+// listen stream is broken, register again with backoff.
+func (c *Client) serveReverse(reverse client.Reverse) {
+	delay := reverseRetryMin
+
+	for {
+		start := time.Now()
+
+		err := c.reverseListen(reverse)
+		if c.closed.Load() {
+			return
+		}
+
+		// the tunnel worked for a while, it is not a persistent failure
+		if time.Since(start) > reverseRetryMax {
+			delay = reverseRetryMin
+		}
+
+		err = errors.Errorf("reverse tunnel %d -> %s broken, retry after %s: %w", reverse.RemotePort, reverse.LocalAddr, delay, err)
+		log.Warnf("%v", err)
+
+		select {
+		case <-c.closeChan:
+			return
+
+		case <-time.After(delay):
+		}
+
+		if delay *= 2; delay > reverseRetryMax {
+			delay = reverseRetryMax
+		}
+	}
+}
+
+// reverseListen register the reverse tunnel and accept the inbound connections until the
+// listen stream is closed.
+func (c *Client) reverseListen(reverse client.Reverse) error {
+	preData := make([]byte, 3)
+	preData[0] = proto.CmdReverseListen
+	binary.BigEndian.PutUint16(preData[1:], reverse.RemotePort)
+
+	ctx := context.Background()
+
+	if timeout := c.timeout.Load(); timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	sess := c.acquireSession()
+	defer sess.release()
+
+	listenConn, err := c.openSessionOn(ctx, sess, sess.Client, preData)
+	if err != nil {
+		return err
+	}
+
+	// the tokens are only known by the server of listenConn, accept streams are opened on it,
+	// even after the session is replaced by reload
+	opener, ok := sessionOpener(listenConn)
+	if !ok {
+		opener = sess.Client
+	}
+
+	c.reverseConns.Store(listenConn, struct{}{})
+
+	defer func() {
+		c.reverseConns.Delete(listenConn)
+		_ = listenConn.Close()
+	}()
+
+	// closed by shutdown between Store and here
+	if c.closed.Load() {
+		return errors.New("client is closed")
+	}
+
+	reply := make([]byte, 1)
+	if _, err := io.ReadFull(listenConn, reply); err != nil {
+		return errors.Errorf("read reverse listen reply failed: %w", err)
+	}
+
+	if reply[0] != proto.ReverseOK {
+		return errors.Errorf("server refuse to listen port %d", reverse.RemotePort)
+	}
+
+	log.Infof("reverse tunnel server port %d -> %s", reverse.RemotePort, reverse.LocalAddr)
+
+	for {
+		token := make([]byte, proto.ReverseTokenSize)
+		if _, err := io.ReadFull(listenConn, token); err != nil {
+			return errors.Errorf("read reverse token failed: %w", err)
+		}
+
+		go c.reverseAccept(reverse, token, sess, opener)
+	}
+}
+
+// reverseAccept open the accept stream of token by opener of sess, and relay it with a conn to
+// the local addr.
+func (c *Client) reverseAccept(reverse client.Reverse, token []byte, sess *clientSession, opener session.Client) {
+	source := net.JoinHostPort("server", strconv.Itoa(int(reverse.RemotePort)))
+	stream := c.accessLog.Start(c.connIdGen.Inc(), source, reverse.LocalAddr, "")
+
+	sessionConn, err := c.openProxyOn(context.Background(), sess, opener, append([]byte{proto.CmdReverseAccept}, token...))
+	if err != nil {
+		stream.SetReason("dial failed: " + err.Error())
+		stream.End()
+
+		err = errors.Errorf("open reverse accept stream failed: %w", err)
+		log.Errorf("%+v", err)
+
+		return
+	}
+
+	stream.SetUser(sessionUser(sessionConn))
+
+	local, err := net.DialTimeout("tcp", reverse.LocalAddr, c.timeout.Load())
+	if err != nil {
+		stream.SetReason("dial failed: " + err.Error())
+		stream.End()
+
+		err = errors.Errorf("dial reverse local addr %s failed: %w", reverse.LocalAddr, err)
+		log.Errorf("%+v", err)
+
+		// server close the inbound connection
+		_ = sessionConn.Close()
+
+		return
+	}
+
+	// the inbound connection is the source, local addr is the target
+	local = stream.Conn(local)
+
+	go func() {
+		_, err := io.Copy(metrics.CountWriter(local, metrics.DirectionIn), sessionConn)
+		stream.SetReason(accesslog.Reason("client", err))
+
+		_ = local.Close()
+		_ = sessionConn.Close()
+	}()
+
+	go func() {
+		_, err := io.Copy(metrics.CountWriter(sessionConn, metrics.DirectionOut), local)
+		stream.SetReason(accesslog.Reason("target", err))
+
+		_ = local.Close()
+		_ = sessionConn.Close()
+	}()
+}
+
+// closeReverse unregister the reverse tunnels, server stop accepting their inbound connections.
+func (c *Client) closeReverse() {
+	c.reverseConns.Range(func(key, _ interface{}) bool {
+		_ = key.(net.Conn).Close()
+
+		return true
+	})
+}
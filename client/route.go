@@ -0,0 +1,164 @@
+package client
+
+import (
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/Sherlock-Holo/camouflage/config/client"
+	"github.com/Sherlock-Holo/camouflage/utils"
+	"github.com/Sherlock-Holo/libsocks"
+	errors "golang.org/x/xerrors"
+)
+
+type routeAction int
+
+const (
+	actionProxy routeAction = iota
+	actionDirect
+	actionReject
+)
+
+func (a routeAction) String() string {
+	switch a {
+	case actionDirect:
+		return client.RouteDirect
+
+	case actionReject:
+		return client.RouteReject
+
+	default:
+		return client.RouteProxy
+	}
+}
+
+func parseRouteAction(action string) (routeAction, error) {
+	switch action {
+	case "", client.RouteProxy:
+		return actionProxy, nil
+
+	case client.RouteDirect:
+		return actionDirect, nil
+
+	case client.RouteReject:
+		return actionReject, nil
+
+	default:
+		return 0, errors.Errorf("unknown route action %s", action)
+	}
+}
+
+type routeRule struct {
+	action routeAction
+	match  func(address libsocks.Address) bool
+}
+
+// router choose the action of a target by ordered rules, the first matched rule decides.
+// Domain rules only match domain targets, cidr rules only match IP targets.
+type router struct {
+	rules         []routeRule
+	defaultAction routeAction
+}
+
+func newRouter(cfg client.Route) (*router, error) {
+	defaultAction, err := parseRouteAction(cfg.Default)
+	if err != nil {
+		return nil, errors.Errorf("parse default route failed: %w", err)
+	}
+
+	r := &router{defaultAction: defaultAction}
+
+	for i, ruleCfg := range cfg.Rules {
+		action, err := parseRouteAction(ruleCfg.Action)
+		if err != nil {
+			return nil, errors.Errorf("parse route rule %d failed: %w", i, err)
+		}
+
+		match, err := newRouteMatcher(ruleCfg.Type, ruleCfg.Value)
+		if err != nil {
+			return nil, errors.Errorf("parse route rule %d failed: %w", i, err)
+		}
+
+		r.rules = append(r.rules, routeRule{
+			action: action,
+			match:  match,
+		})
+	}
+
+	return r, nil
+}
+
+func newRouteMatcher(ruleType, value string) (func(address libsocks.Address) bool, error) {
+	switch ruleType {
+	case client.RuleDomainSuffix:
+		suffix := strings.ToLower(strings.Trim(value, "."))
+
+		return func(address libsocks.Address) bool {
+			domain := targetDomain(address)
+
+			return domain != "" && (domain == suffix || strings.HasSuffix(domain, "."+suffix))
+		}, nil
+
+	case client.RuleDomainKeyword:
+		keyword := strings.ToLower(value)
+
+		return func(address libsocks.Address) bool {
+			domain := targetDomain(address)
+
+			return domain != "" && strings.Contains(domain, keyword)
+		}, nil
+
+	case client.RuleDomainRegex:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, errors.Errorf("compile regex %s failed: %w", value, err)
+		}
+
+		return func(address libsocks.Address) bool {
+			domain := targetDomain(address)
+
+			return domain != "" && re.MatchString(domain)
+		}, nil
+
+	case client.RuleCIDR:
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, errors.Errorf("parse cidr %s failed: %w", value, err)
+		}
+
+		return func(address libsocks.Address) bool {
+			return address.Type != libsocks.TypeDomain && ipNet.Contains(address.IP)
+		}, nil
+
+	case client.RulePort:
+		ports, err := utils.ParsePortRanges(value)
+		if err != nil {
+			return nil, errors.Errorf("parse ports %s failed: %w", value, err)
+		}
+
+		return func(address libsocks.Address) bool {
+			return ports.Contains(address.Port)
+		}, nil
+
+	default:
+		return nil, errors.Errorf("unknown rule type %s", ruleType)
+	}
+}
+
+func targetDomain(address libsocks.Address) string {
+	if address.Type != libsocks.TypeDomain {
+		return ""
+	}
+
+	return strings.ToLower(strings.TrimSuffix(address.Host, "."))
+}
+
+func (r *router) route(address libsocks.Address) routeAction {
+	for _, rule := range r.rules {
+		if rule.match(address) {
+			return rule.action
+		}
+	}
+
+	return r.defaultAction
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/Sherlock-Holo/camouflage/config/client"
+	"github.com/Sherlock-Holo/libsocks"
+)
+
+func domainAddress(host string, port uint16) libsocks.Address {
+	return libsocks.Address{Type: libsocks.TypeDomain, Host: host, Port: port}
+}
+
+func TestRouterRoute(t *testing.T) {
+	r, err := newRouter(client.Route{
+		Default: client.RouteProxy,
+		Rules: []client.RouteRule{
+			{Type: client.RulePort, Value: "25,465-587", Action: client.RouteReject},
+			{Type: client.RuleDomainSuffix, Value: "example.cn", Action: client.RouteDirect},
+			{Type: client.RuleDomainKeyword, Value: "ADS", Action: client.RouteReject},
+			{Type: client.RuleDomainRegex, Value: `^api\d+\.example\.com$`, Action: client.RouteDirect},
+			{Type: client.RuleCIDR, Value: "192.168.0.0/16", Action: client.RouteDirect},
+			{Type: client.RuleCIDR, Value: "fd00::/8", Action: client.RouteDirect},
+			{Type: client.RuleDomainSuffix, Value: "ads.example.cn", Action: client.RouteProxy},
+		},
+	})
+	if err != nil {
+		t.Fatalf("newRouter failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		address libsocks.Address
+		action  routeAction
+	}{
+		{"default", domainAddress("example.com", 443), actionProxy},
+		{"default ip", ipAddress(net.ParseIP("8.8.8.8"), 443), actionProxy},
+		{"port rule first", domainAddress("example.cn", 25), actionReject},
+		{"port range", ipAddress(net.ParseIP("192.168.1.1"), 500), actionReject},
+		{"port range end", domainAddress("example.com", 587), actionReject},
+		{"domain suffix", domainAddress("example.cn", 443), actionDirect},
+		{"subdomain suffix", domainAddress("www.example.cn", 443), actionDirect},
+		{"suffix needs a dot", domainAddress("myexample.cn", 443), actionProxy},
+		{"suffix case and trailing dot", domainAddress("WWW.Example.CN.", 443), actionDirect},
+		{"earlier rule wins", domainAddress("ads.example.cn", 443), actionDirect},
+		{"keyword is case-insensitive", domainAddress("myads.example.com", 443), actionReject},
+		{"regex", domainAddress("api12.example.com", 443), actionDirect},
+		{"regex not matched", domainAddress("api.example.com", 443), actionProxy},
+		{"cidr", ipAddress(net.ParseIP("192.168.1.1"), 443), actionDirect},
+		{"ipv6 cidr", ipAddress(net.ParseIP("fd00::1"), 443), actionDirect},
+		{"cidr doesn't match domain", domainAddress("192.168.1.1", 443), actionProxy},
+		{"domain rule doesn't match ip", ipAddress(net.ParseIP("8.8.4.4"), 80), actionProxy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if action := r.route(tt.address); action != tt.action {
+				t.Errorf("route(%s) = %s, want %s", tt.address, action, tt.action)
+			}
+		})
+	}
+}
+
+func TestRouterDefault(t *testing.T) {
+	tests := []struct {
+		defaultAction string
+		action        routeAction
+	}{
+		{"", actionProxy},
+		{client.RouteProxy, actionProxy},
+		{client.RouteDirect, actionDirect},
+		{client.RouteReject, actionReject},
+	}
+
+	for _, tt := range tests {
+		r, err := newRouter(client.Route{Default: tt.defaultAction})
+		if err != nil {
+			t.Fatalf("newRouter(%q) failed: %v", tt.defaultAction, err)
+		}
+
+		if action := r.route(domainAddress("example.com", 443)); action != tt.action {
+			t.Errorf("default %q route = %s, want %s", tt.defaultAction, action, tt.action)
+		}
+	}
+}
+
+func TestNewRouterInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		route client.Route
+	}{
+		{"unknown default", client.Route{Default: "drop"}},
+		{"unknown action", client.Route{Rules: []client.RouteRule{{Type: client.RulePort, Value: "80", Action: "drop"}}}},
+		{"unknown type", client.Route{Rules: []client.RouteRule{{Type: "geoip", Value: "CN"}}}},
+		{"invalid regex", client.Route{Rules: []client.RouteRule{{Type: client.RuleDomainRegex, Value: "("}}}},
+		{"invalid cidr", client.Route{Rules: []client.RouteRule{{Type: client.RuleCIDR, Value: "192.168.0.0"}}}},
+		{"invalid port", client.Route{Rules: []client.RouteRule{{Type: client.RulePort, Value: "http"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newRouter(tt.route); err == nil {
+				t.Error("newRouter succeeded, want error")
+			}
+		})
+	}
+}
@@ -1,54 +1,171 @@
 package client
 
 import (
+	"io"
 	"net"
 
 	"github.com/Sherlock-Holo/libsocks"
 	errors "golang.org/x/xerrors"
 )
 
+const (
+	socksCmdConnect      = 1
+	socksCmdUDPAssociate = 3
+
+	socksNoAuth       = 0
+	socksNoAcceptable = 0xff
+)
+
 type Socks struct {
-	socks *libsocks.SocksServer
+	net.Conn
+
+	cmd    byte
+	target libsocks.Address
 }
 
+// NewSocks NewSocks will auth socks client and read socks request, if error, conn will be closed
 func NewSocks(conn net.Conn) (socks *Socks, err error) {
-	s, err := libsocks.NewSocks(conn, nil)
-	if err != nil {
+	s := &Socks{Conn: conn}
+
+	if err := s.init(); err != nil {
 		err = errors.Errorf("NewSocks failed: %w", err)
-		conn.Close()
-		return
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Socks) init() error {
+	verMsg := make([]byte, 2)
+	if _, err := io.ReadFull(s.Conn, verMsg); err != nil {
+		return errors.Errorf("socks read version failed: %w", err)
+	}
+
+	if verMsg[0] != libsocks.Version {
+		return errors.Errorf("socks auth version wrong: %w", libsocks.VersionErr{SourceAddr: s.RemoteAddr(), SocksVersion: verMsg[0]})
+	}
+
+	methods := make([]byte, verMsg[1])
+	if _, err := io.ReadFull(s.Conn, methods); err != nil {
+		return errors.Errorf("socks read auth methods failed: %w", err)
+	}
+
+	var coincide bool
+	for _, method := range methods {
+		if method == socksNoAuth {
+			coincide = true
+			break
+		}
+	}
+
+	if !coincide {
+		_, _ = s.Conn.Write([]byte{libsocks.Version, socksNoAcceptable})
+		return errors.New("socks no-auth method not offered")
+	}
+
+	if _, err := s.Conn.Write([]byte{libsocks.Version, socksNoAuth}); err != nil {
+		return errors.Errorf("socks write auth method failed: %w", err)
+	}
+
+	// [version 1 byte | cmd 1 byte | rsv 1 byte]
+	request := make([]byte, 3)
+	if _, err := io.ReadFull(s.Conn, request); err != nil {
+		return errors.Errorf("socks read request failed: %w", err)
+	}
+
+	if request[0] != libsocks.Version {
+		return errors.Errorf("socks request version wrong: %w", libsocks.VersionErr{SourceAddr: s.RemoteAddr(), SocksVersion: request[0]})
+	}
+
+	target, err := libsocks.UnmarshalAddressFrom(s.Conn)
+	if err != nil {
+		return errors.Errorf("socks read target failed: %w", err)
+	}
+
+	switch request[1] {
+	case socksCmdConnect, socksCmdUDPAssociate:
+	default:
+		_ = s.reply(s.LocalAddr(), libsocks.CmdNotSupport)
+		return errors.Errorf("socks cmd %d not support", request[1])
 	}
 
-	return &Socks{
-		socks: s,
-	}, nil
+	s.cmd = request[1]
+	s.target = target
+
+	return nil
 }
 
 func (s *Socks) Handshake(respType libsocks.ResponseType) error {
-	if err := s.socks.Reply(s.socks.LocalAddr().(*net.TCPAddr).IP, uint16(s.socks.LocalAddr().(*net.TCPAddr).Port), respType); err != nil {
+	if err := s.reply(s.LocalAddr(), respType); err != nil {
 		return errors.Errorf("socks handshake failed: %w", err)
 	}
 	return nil
 }
 
+// reply write socks reply with the bind address.
+func (s *Socks) reply(bind net.Addr, respType libsocks.ResponseType) error {
+	var (
+		ip   net.IP
+		port int
+	)
+
+	switch addr := bind.(type) {
+	case *net.TCPAddr:
+		ip, port = addr.IP, addr.Port
+
+	case *net.UDPAddr:
+		ip, port = addr.IP, addr.Port
+
+	default:
+		ip = net.IPv4zero
+	}
+
+	address := ipAddress(ip, port)
+
+	if _, err := s.Conn.Write(append([]byte{libsocks.Version, respType, 0}, address.Bytes()...)); err != nil {
+		return errors.Errorf("socks write reply failed: %w", err)
+	}
+
+	return nil
+}
+
+// ipAddress return the socks address of ip and port, IPv4 is used when ip is an IPv4 or
+// IPv4-mapped address.
+func ipAddress(ip net.IP, port int) libsocks.Address {
+	address := libsocks.Address{
+		Type: libsocks.TypeIPv6,
+		IP:   ip.To16(),
+		Port: uint16(port),
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		address.Type = libsocks.TypeIPv4
+		address.IP = ip4
+	}
+
+	return address
+}
+
 func (s *Socks) Target() []byte {
-	return s.socks.Target.Bytes()
+	return s.target.Bytes()
+}
+
+// IsUDPAssociate report if the socks request is UDP ASSOCIATE.
+func (s *Socks) IsUDPAssociate() bool {
+	return s.cmd == socksCmdUDPAssociate
 }
 
 func (s *Socks) Read(p []byte) (n int, err error) {
-	if n, err = s.socks.Read(p); err != nil {
+	if n, err = s.Conn.Read(p); err != nil {
 		err = errors.Errorf("socks read failed: %w", err)
 	}
 	return
 }
 
 func (s *Socks) Write(p []byte) (n int, err error) {
-	if n, err = s.socks.Write(p); err != nil {
+	if n, err = s.Conn.Write(p); err != nil {
 		err = errors.Errorf("socks write failed: %w", err)
 	}
 	return
 }
-
-func (s *Socks) Close() error {
-	return s.socks.Close()
-}
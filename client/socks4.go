@@ -0,0 +1,152 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+
+	"github.com/Sherlock-Holo/libsocks"
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+const (
+	socks4Version = 4
+
+	socks4Granted  = 0x5a
+	socks4Rejected = 0x5b
+)
+
+// Socks4 is a socks4 and socks4a server conn, only support CONNECT.
+type Socks4 struct {
+	net.Conn
+
+	target libsocks.Address
+}
+
+// NewSocks4 read socks4 request, if error, conn will be closed.
+func NewSocks4(conn net.Conn) (*Socks4, error) {
+	s := &Socks4{Conn: conn}
+
+	if err := s.init(); err != nil {
+		err = errors.Errorf("NewSocks4 failed: %w", err)
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Socks4) init() error {
+	// [version 1 byte | cmd 1 byte | port 2 bytes | ip 4 bytes | user id | 0x00]
+	request := make([]byte, 8)
+	if _, err := io.ReadFull(s.Conn, request); err != nil {
+		return errors.Errorf("socks4 read request failed: %w", err)
+	}
+
+	if request[0] != socks4Version {
+		return errors.Errorf("socks4 request version wrong: %w", libsocks.VersionErr{SourceAddr: s.RemoteAddr(), SocksVersion: request[0]})
+	}
+
+	if _, err := readNullTerminated(s.Conn); err != nil {
+		return errors.Errorf("socks4 read user id failed: %w", err)
+	}
+
+	if request[1] != socksCmdConnect {
+		_ = s.Handshake(false)
+		return errors.Errorf("socks4 cmd %d not support", request[1])
+	}
+
+	s.target.Port = binary.BigEndian.Uint16(request[2:4])
+
+	ip := net.IP(request[4:8])
+
+	// socks4a: ip is 0.0.0.x and x != 0, domain name follows the user id
+	if ip[0] == 0 && ip[1] == 0 && ip[2] == 0 && ip[3] != 0 {
+		domain, err := readNullTerminated(s.Conn)
+		if err != nil {
+			return errors.Errorf("socks4a read domain failed: %w", err)
+		}
+
+		s.target.Type = libsocks.TypeDomain
+		s.target.Host = string(domain)
+	} else {
+		s.target.Type = libsocks.TypeIPv4
+		s.target.IP = ip
+	}
+
+	return nil
+}
+
+func (s *Socks4) Handshake(granted bool) error {
+	// [version 0 | reply | port 2 bytes | ip 4 bytes], port and ip are ignored by client
+	reply := []byte{0, socks4Rejected, 0, 0, 0, 0, 0, 0}
+	if granted {
+		reply[1] = socks4Granted
+	}
+
+	if _, err := s.Conn.Write(reply); err != nil {
+		return errors.Errorf("socks4 handshake failed: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Socks4) Target() []byte {
+	return s.target.Bytes()
+}
+
+// readNullTerminated read a null terminated string, byte by byte so the data after it won't be lost.
+func readNullTerminated(r io.Reader) ([]byte, error) {
+	var (
+		b   []byte
+		buf = make([]byte, 1)
+	)
+
+	for {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+
+		if buf[0] == 0 {
+			return b, nil
+		}
+
+		if len(b) == 255 {
+			return nil, errors.New("null terminated string too long")
+		}
+
+		b = append(b, buf[0])
+	}
+}
+
+func (c *Client) handleSocks4(conn net.Conn) {
+	socks, err := NewSocks4(conn)
+	if err != nil {
+		err = errors.Errorf("client handle error: %w", err)
+		log.Errorf("%+v", err)
+		return
+	}
+
+	sessionConn, err := c.dial(context.Background(), socks.RemoteAddr().String(), socks.Target())
+	if err != nil {
+		if !errors.Is(err, errDialQueueFull) && !errors.Is(err, errRejected) {
+			log.Errorf("client handle error: %+v", err)
+		}
+
+		_ = socks.Handshake(false)
+		_ = socks.Close()
+		return
+	}
+
+	if err := socks.Handshake(true); err != nil {
+		err := errors.Errorf("client handle error: %w", err)
+		log.Errorf("%+v", err)
+		_ = socks.Close()
+		_ = sessionConn.Close()
+		return
+	}
+
+	relay(socks, sessionConn)
+}
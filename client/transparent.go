@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+// serveTransparent accept the conns redirected by iptables until listener is closed.
+func (c *Client) serveTransparent(listener net.Listener, mode string) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			err = errors.Errorf("accept transparent failed: %w", err)
+			log.Errorf("%v", err)
+			continue
+		}
+
+		go c.handleTransparent(conn, listener.Addr(), mode)
+	}
+}
+
+// handleTransparent tunnel conn to its original destination like a socks CONNECT.
+func (c *Client) handleTransparent(conn net.Conn, listenAddr net.Addr, mode string) {
+	ip, port, err := originalDst(conn, mode)
+	if err != nil {
+		err = errors.Errorf("client handle transparent error: %w", err)
+		log.Errorf("%+v", err)
+		_ = conn.Close()
+		return
+	}
+
+	// the conn is not redirected, tunnel it to the listener itself is a loop
+	if listenTCPAddr, ok := listenAddr.(*net.TCPAddr); ok && port == listenTCPAddr.Port {
+		if ip.Equal(listenTCPAddr.IP) || listenTCPAddr.IP.IsUnspecified() && ip.IsLoopback() {
+			log.Warnf("transparent conn from %s is not redirected", conn.RemoteAddr())
+			_ = conn.Close()
+			return
+		}
+	}
+
+	preData := ipAddress(ip, port).Bytes()
+
+	sessionConn, err := c.dial(context.Background(), conn.RemoteAddr().String(), preData)
+	if err != nil {
+		if !errors.Is(err, errDialQueueFull) && !errors.Is(err, errRejected) {
+			log.Errorf("client handle transparent error: %+v", err)
+		}
+
+		_ = conn.Close()
+		return
+	}
+
+	relay(conn, sessionConn)
+}
@@ -0,0 +1,119 @@
+//go:build linux
+
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"github.com/Sherlock-Holo/camouflage/config/client"
+	"golang.org/x/sys/unix"
+	errors "golang.org/x/xerrors"
+)
+
+// listenTransparent listen addr for mode, TPROXY need IP_TRANSPARENT on the listener to accept
+// the conns of non-local destinations.
+func listenTransparent(addr, mode string) (net.Listener, error) {
+	var listenConfig net.ListenConfig
+
+	if mode == client.TransparentTProxy {
+		listenConfig.Control = func(network, _ string, rawConn syscall.RawConn) error {
+			level, opt := unix.SOL_IP, unix.IP_TRANSPARENT
+			if network == "tcp6" {
+				level, opt = unix.SOL_IPV6, unix.IPV6_TRANSPARENT
+			}
+
+			var sockErr error
+
+			if err := rawConn.Control(func(fd uintptr) {
+				sockErr = unix.SetsockoptInt(int(fd), level, opt, 1)
+			}); err != nil {
+				return err
+			}
+
+			if sockErr != nil {
+				return errors.Errorf("set transparent socket option failed: %w", sockErr)
+			}
+
+			return nil
+		}
+	}
+
+	listener, err := listenConfig.Listen(context.Background(), "tcp", addr)
+	if err != nil {
+		return nil, errors.Errorf("transparent listen failed: %w", err)
+	}
+
+	return listener, nil
+}
+
+// originalDst return the destination of conn before it is redirected. With TPROXY the local
+// address is kept, with REDIRECT it is read from conntrack by SO_ORIGINAL_DST.
+func originalDst(conn net.Conn, mode string) (net.IP, int, error) {
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, 0, errors.Errorf("transparent conn local addr %s is not tcp", conn.LocalAddr())
+	}
+
+	if mode == client.TransparentTProxy {
+		return local.IP, local.Port, nil
+	}
+
+	syscallConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, 0, errors.New("transparent conn is not a socket")
+	}
+
+	rawConn, err := syscallConn.SyscallConn()
+	if err != nil {
+		return nil, 0, errors.Errorf("get transparent raw conn failed: %w", err)
+	}
+
+	// IP6T_SO_ORIGINAL_DST has the same value as SO_ORIGINAL_DST
+	level := unix.SOL_IP
+	if local.IP.To4() == nil {
+		level = unix.SOL_IPV6
+	}
+
+	// large enough for sockaddr_in and sockaddr_in6
+	var sockaddr [unix.SizeofSockaddrInet6]byte
+	size := uint32(len(sockaddr))
+
+	var errno syscall.Errno
+
+	if err := rawConn.Control(func(fd uintptr) {
+		_, _, errno = unix.Syscall6(
+			unix.SYS_GETSOCKOPT,
+			fd,
+			uintptr(level),
+			unix.SO_ORIGINAL_DST,
+			uintptr(unsafe.Pointer(&sockaddr[0])),
+			uintptr(unsafe.Pointer(&size)),
+			0,
+		)
+	}); err != nil {
+		return nil, 0, errors.Errorf("control transparent conn failed: %w", err)
+	}
+
+	if errno != 0 {
+		return nil, 0, errors.Errorf("get SO_ORIGINAL_DST failed: %w", errno)
+	}
+
+	// [family 2 bytes | port 2 bytes | IPv4 4 bytes] or
+	// [family 2 bytes | port 2 bytes | flow info 4 bytes | IPv6 16 bytes | scope id 4 bytes]
+	port := int(binary.BigEndian.Uint16(sockaddr[2:4]))
+
+	switch binary.NativeEndian.Uint16(sockaddr[:2]) {
+	case unix.AF_INET:
+		return net.IP(sockaddr[4:8]), port, nil
+
+	case unix.AF_INET6:
+		return net.IP(sockaddr[8:24]), port, nil
+
+	default:
+		return nil, 0, errors.Errorf("unknown SO_ORIGINAL_DST family %d", binary.NativeEndian.Uint16(sockaddr[:2]))
+	}
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package client
+
+import (
+	"net"
+
+	errors "golang.org/x/xerrors"
+)
+
+var errTransparentUnsupported = errors.New("transparent proxy is only supported on linux")
+
+func listenTransparent(string, string) (net.Listener, error) {
+	return nil, errTransparentUnsupported
+}
+
+func originalDst(net.Conn, string) (net.IP, int, error) {
+	return nil, 0, errTransparentUnsupported
+}
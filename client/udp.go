@@ -0,0 +1,151 @@
+package client
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/Sherlock-Holo/camouflage/accesslog"
+	"github.com/Sherlock-Holo/camouflage/metrics"
+	"github.com/Sherlock-Holo/camouflage/proto"
+	"github.com/Sherlock-Holo/libsocks"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	errors "golang.org/x/xerrors"
+)
+
+// handleUDP relay socks UDP ASSOCIATE datagrams over sessionConn, the association
+// lives as long as the socks TCP connection.
+func (c *Client) handleUDP(socks *Socks, sessionConn net.Conn) {
+	socksAddr := socks.LocalAddr().(*net.TCPAddr)
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: socksAddr.IP, Zone: socksAddr.Zone})
+	if err != nil {
+		err = errors.Errorf("listen socks udp failed: %w", err)
+		log.Errorf("%+v", err)
+		_ = socks.Handshake(libsocks.ServerFailed)
+		_ = socks.Close()
+		_ = sessionConn.Close()
+		return
+	}
+
+	if err := socks.reply(udpConn.LocalAddr(), libsocks.Success); err != nil {
+		err = errors.Errorf("client handle error: %w", err)
+		log.Errorf("%+v", err)
+		_ = socks.Close()
+		_ = sessionConn.Close()
+		_ = udpConn.Close()
+		return
+	}
+
+	log.Debugf("socks udp associate on %s", udpConn.LocalAddr())
+
+	var closeOnce sync.Once
+	closeAll := func() {
+		closeOnce.Do(func() {
+			_ = socks.Close()
+			_ = sessionConn.Close()
+			_ = udpConn.Close()
+		})
+	}
+
+	go func() {
+		_, err := io.Copy(io.Discard, socks)
+		accesslog.SetReason(sessionConn, accesslog.Reason("client", err))
+
+		closeAll()
+	}()
+
+	// only the socks client can use the association, the port is learned from the first datagram
+	clientIP := socks.RemoteAddr().(*net.TCPAddr).IP
+	var clientAddr atomic.Value
+
+	go func() {
+		defer closeAll()
+
+		buf := make([]byte, proto.MaxDatagramSize)
+
+		for {
+			n, addr, err := udpConn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+
+			if !addr.IP.Equal(clientIP) {
+				continue
+			}
+
+			clientAddr.Store(addr)
+
+			address, payload, err := unmarshalSocksDatagram(buf[:n])
+			if err != nil {
+				err = errors.Errorf("unmarshal socks datagram failed: %w", err)
+				log.Debugf("%v", err)
+				continue
+			}
+
+			metrics.Bytes.WithLabelValues(metrics.DirectionOut).Add(float64(len(payload)))
+
+			if err := proto.WriteDatagram(sessionConn, address, payload); err != nil {
+				err = errors.Errorf("write datagram to session failed: %w", err)
+				log.Debugf("%v", err)
+				return
+			}
+		}
+	}()
+
+	go func() {
+		defer closeAll()
+
+		reader := bufio.NewReader(sessionConn)
+
+		for {
+			address, payload, err := proto.ReadDatagram(reader)
+			if err != nil {
+				accesslog.SetReason(sessionConn, accesslog.Reason("target", err))
+
+				return
+			}
+
+			metrics.Bytes.WithLabelValues(metrics.DirectionIn).Add(float64(len(payload)))
+
+			addr, ok := clientAddr.Load().(*net.UDPAddr)
+			if !ok {
+				continue
+			}
+
+			if _, err := udpConn.WriteToUDP(marshalSocksDatagram(address, payload), addr); err != nil {
+				err = errors.Errorf("write socks datagram failed: %w", err)
+				log.Debugf("%v", err)
+			}
+		}
+	}()
+}
+
+// unmarshalSocksDatagram parse socks UDP request header.
+//
+// [rsv 2 bytes | frag 1 byte | address | payload]
+func unmarshalSocksDatagram(b []byte) (libsocks.Address, []byte, error) {
+	if len(b) < 3 {
+		return libsocks.Address{}, nil, errors.New("socks datagram too short")
+	}
+
+	// fragmentation is not supported
+	if b[2] != 0 {
+		return libsocks.Address{}, nil, errors.Errorf("socks datagram fragment %d not support", b[2])
+	}
+
+	address, err := libsocks.UnmarshalAddress(b[3:])
+	if err != nil {
+		return libsocks.Address{}, nil, err
+	}
+
+	return address, b[3+len(address.Bytes()):], nil
+}
+
+func marshalSocksDatagram(address libsocks.Address, payload []byte) []byte {
+	b := append([]byte{0, 0, 0}, address.Bytes()...)
+
+	return append(b, payload...)
+}
@@ -5,6 +5,7 @@ import (
 	config "github.com/Sherlock-Holo/camouflage/config/client"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"go.uber.org/atomic"
 )
 
 var clientConfig string
@@ -28,8 +29,31 @@ var clientCmd = &cobra.Command{
 			return err
 		}
 
+		var drainTimeout atomic.Duration
+
+		drainTimeout.Store(cfg.DrainTimeout.Duration)
+
+		go reloadOnSignal(func() error {
+			cfg, err := config.New(clientConfig)
+			if err != nil {
+				return err
+			}
+
+			if err := c.Reload(&cfg); err != nil {
+				return err
+			}
+
+			drainTimeout.Store(cfg.DrainTimeout.Duration)
+
+			return nil
+		})
+
+		go drainOnSignal(c.Shutdown, drainTimeout.Load)
+
 		c.Run()
 
+		log.Info("client exit")
+
 		return nil
 	},
 }
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadOnSignal call reload when receive SIGHUP, if reload failed, the old config is kept.
+func reloadOnSignal(reload func() error) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		log.Info("reload config")
+
+		if err := reload(); err != nil {
+			log.Errorf("reload config failed, keep the old config: %+v", err)
+			continue
+		}
+
+		log.Info("reload config success")
+	}
+}
+
+const defaultDrainTimeout = 30 * time.Second
+
+// drainOnSignal call shutdown when receive SIGTERM or SIGINT, the conns are drained until
+// timeout, a second signal stops draining immediately.
+func drainOnSignal(shutdown func(ctx context.Context) error, timeout func() time.Duration) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	sig := <-signals
+
+	drainTimeout := timeout()
+	if drainTimeout <= 0 {
+		drainTimeout = defaultDrainTimeout
+	}
+
+	log.Infof("receive %s, drain conns in %s", sig, drainTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+
+		case sig := <-signals:
+			log.Warnf("receive %s again, stop draining", sig)
+			cancel()
+		}
+	}()
+
+	if err := shutdown(ctx); err != nil {
+		log.Errorf("shutdown failed: %+v", err)
+	}
+}
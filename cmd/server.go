@@ -5,6 +5,7 @@ import (
 	"github.com/Sherlock-Holo/camouflage/server"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"go.uber.org/atomic"
 )
 
 var serverConfig string
@@ -28,8 +29,31 @@ var serverCmd = &cobra.Command{
 			return err
 		}
 
+		var drainTimeout atomic.Duration
+
+		drainTimeout.Store(cfg.DrainTimeout.Duration)
+
+		go reloadOnSignal(func() error {
+			cfg, err := config.New(serverConfig)
+			if err != nil {
+				return err
+			}
+
+			if err := server.Reload(&cfg); err != nil {
+				return err
+			}
+
+			drainTimeout.Store(cfg.DrainTimeout.Duration)
+
+			return nil
+		})
+
+		go drainOnSignal(server.Shutdown, drainTimeout.Load)
+
 		server.Run()
 
+		log.Info("server exit")
+
 		return nil
 	},
 }
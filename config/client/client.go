@@ -21,18 +21,153 @@ const (
 	TypeQuic      = "quic"
 )
 
+const (
+	RouteProxy  = "proxy"
+	RouteDirect = "direct"
+	RouteReject = "reject"
+
+	RuleDomainSuffix  = "domain_suffix"
+	RuleDomainKeyword = "domain_keyword"
+	RuleDomainRegex   = "domain_regex"
+	RuleCIDR          = "cidr"
+	RulePort          = "port"
+)
+
+type RouteRule struct {
+	Type   string `toml:"type"`
+	Value  string `toml:"value"`
+	Action string `toml:"action"` // proxy, direct or reject
+}
+
+type Route struct {
+	Default string      `toml:"default"` // action when no rule match, default is proxy
+	Rules   []RouteRule `toml:"rules"`
+}
+
+const (
+	PolicyFailover      = "failover"
+	PolicyRoundRobin    = "round_robin"
+	PolicyLowestLatency = "lowest_latency"
+)
+
+// Server is an upstream server, when Config.Servers is empty, the top level server fields are used.
+type Server struct {
+	Type      string `toml:"type"`
+	Host      string `toml:"host"`
+	Path      string `toml:"path"`
+	DebugCA   string `toml:"debug_ca"`
+	ClientCrt string `toml:"client_crt"`
+	ClientKey string `toml:"client_key"`
+	User      string `toml:"user"`
+	Secret    string `toml:"secret"`
+	Period    uint   `toml:"period"`
+	Weight    uint   `toml:"weight"` // used by round_robin, default is 1
+}
+
+type Upstream struct {
+	Policy        string   `toml:"policy"`         // failover, round_robin or lowest_latency, default is failover
+	CheckInterval Duration `toml:"check_interval"` // health check interval, default is 10s
+}
+
+type Reconnect struct {
+	BackoffBase      Duration `toml:"backoff_base"`      // default is 500ms
+	BackoffMax       Duration `toml:"backoff_max"`       // default is 1m
+	BreakerThreshold int      `toml:"breaker_threshold"` // consecutive failures open the circuit breaker, default is 5
+}
+
+// Forward forward the conns of ListenAddr to Target through server, like ssh -L.
+type Forward struct {
+	ListenAddr string `toml:"listen_addr"`
+	Target     string `toml:"target"` // host:port
+}
+
+// Reverse expose LocalAddr on RemotePort of server.
+type Reverse struct {
+	RemotePort uint16 `toml:"remote_port"`
+	LocalAddr  string `toml:"local_addr"`
+}
+
+const (
+	TransparentRedirect = "redirect"
+	TransparentTProxy   = "tproxy"
+)
+
+// Transparent accept the conns redirected by iptables REDIRECT or TPROXY rules, linux only.
+type Transparent struct {
+	ListenAddr string `toml:"listen_addr"`
+	Mode       string `toml:"mode"` // redirect or tproxy, default is redirect
+}
+
+// DNSLocal resolve Domains and their subdomains by Upstream directly, not through server.
+type DNSLocal struct {
+	Domains  []string `toml:"domains"`
+	Upstream string   `toml:"upstream"` // host:port of the local DNS server
+}
+
+// DNS serve DNS on ListenAddr over UDP and TCP, queries are resolved by server.
+type DNS struct {
+	ListenAddr string     `toml:"listen_addr"`
+	CacheSize  int        `toml:"cache_size"` // max cached answers, default is 4096
+	Local      []DNSLocal `toml:"local"`
+}
+
+type HTTP struct {
+	ListenAddr string `toml:"listen_addr"`
+}
+
 type Config struct {
-	Type       string   `toml:"type"` // support websocket and quic
-	Host       string   `toml:"host"`
-	Path       string   `toml:"path"`
-	DebugCA    string   `toml:"debug_ca"`
-	ListenAddr string   `toml:"listen_addr"`
-	Timeout    Duration `toml:"timeout"`
-	Secret     string   `toml:"secret"`
-	Period     uint     `toml:"period"`
-	Pprof      string   `toml:"pprof"`
+	Type         string      `toml:"type"` // support websocket and quic
+	Host         string      `toml:"host"`
+	Path         string      `toml:"path"`
+	DebugCA      string      `toml:"debug_ca"`
+	ClientCrt    string      `toml:"client_crt"` // client certificate for mutual TLS
+	ClientKey    string      `toml:"client_key"`
+	ListenAddr   string      `toml:"listen_addr"`
+	Mixed        bool        `toml:"mixed"`     // listen_addr accept socks4/4a/5 and HTTP proxy
+	PoolSize     int         `toml:"pool_size"` // number of parallel websocket links
+	Timeout      Duration    `toml:"timeout"`
+	MaxDials     int         `toml:"max_dials"` // concurrent session stream opens, default is 256
+	User         string      `toml:"user"`
+	Secret       string      `toml:"secret"`
+	Period       uint        `toml:"period"`
+	Pprof        string      `toml:"pprof"`
+	Metrics      string      `toml:"metrics"` // prometheus /metrics listen addr
+	HTTP         HTTP        `toml:"http"`    // HTTP proxy (optional)
+	Route        Route       `toml:"route"`   // routing rules (optional)
+	Servers      []Server    `toml:"servers"` // multiple upstream servers (optional)
+	Upstream     Upstream    `toml:"upstream"`
+	Reconnect    Reconnect   `toml:"reconnect"`     // reconnect backoff (optional)
+	DrainTimeout Duration    `toml:"drain_timeout"` // wait for conns when shutting down, default is 30s
+	AccessLog    string      `toml:"access_log"`    // JSON access log file path or stdout
+	Reverse      []Reverse   `toml:"reverse"`       // reverse tunnels (optional)
+	Forward      []Forward   `toml:"forward"`       // static local forwards (optional)
+	Transparent  Transparent `toml:"transparent"`   // transparent proxy (optional)
+	DNS          DNS         `toml:"dns"`           // tunneled DNS server (optional)
 }
 
+// UpstreamServers return Servers, or the top level server when Servers is empty.
+func (c Config) UpstreamServers() []Server {
+	if len(c.Servers) > 0 {
+		return c.Servers
+	}
+
+	return []Server{{
+		Type:      c.Type,
+		Host:      c.Host,
+		Path:      c.Path,
+		DebugCA:   c.DebugCA,
+		ClientCrt: c.ClientCrt,
+		ClientKey: c.ClientKey,
+		User:      c.User,
+		Secret:    c.Secret,
+		Period:    c.Period,
+		Weight:    1,
+	}}
+}
+
+// maxUserLength is the max length of user name, quic auth send it with 1 byte length prefix.
+const maxUserLength = 255
+
 type tomlConfig struct {
 	Client Config `toml:"client"`
 }
@@ -43,11 +178,49 @@ func New(path string) (Config, error) {
 		return Config{}, errors.Errorf("new client config failed: %w", err)
 	}
 
-	switch config.Client.Type {
+	for _, server := range config.Client.UpstreamServers() {
+		switch server.Type {
+		default:
+			return Config{}, errors.Errorf("unknown type %s", server.Type)
+
+		case TypeWebsocket, TypeQuic:
+		}
+
+		if len(server.User) > maxUserLength {
+			return Config{}, errors.Errorf("user %s is longer than %d bytes", server.User, maxUserLength)
+		}
+	}
+
+	switch config.Client.Upstream.Policy {
+	default:
+		return Config{}, errors.Errorf("unknown upstream policy %s", config.Client.Upstream.Policy)
+
+	case "", PolicyFailover, PolicyRoundRobin, PolicyLowestLatency:
+	}
+
+	switch config.Client.Transparent.Mode {
 	default:
-		return Config{}, errors.Errorf("unknown type %s", config.Client.Type)
+		return Config{}, errors.Errorf("unknown transparent mode %s", config.Client.Transparent.Mode)
+
+	case "", TransparentRedirect, TransparentTProxy:
+	}
+
+	for _, local := range config.Client.DNS.Local {
+		if len(local.Domains) == 0 || local.Upstream == "" {
+			return Config{}, errors.New("dns local need domains and upstream")
+		}
+	}
+
+	for _, forward := range config.Client.Forward {
+		if forward.ListenAddr == "" || forward.Target == "" {
+			return Config{}, errors.New("forward need listen_addr and target")
+		}
+	}
 
-	case TypeWebsocket, TypeQuic:
+	for _, reverse := range config.Client.Reverse {
+		if reverse.RemotePort == 0 || reverse.LocalAddr == "" {
+			return Config{}, errors.New("reverse need remote_port and local_addr")
+		}
 	}
 
 	return config.Client, nil
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/Sherlock-Holo/camouflage/utils"
 	"golang.org/x/xerrors"
 )
 
@@ -21,6 +22,64 @@ const (
 	TypeQuic      = "quic"
 )
 
+const (
+	ClientAuthRequired = "required"
+	ClientAuthOptional = "optional"
+)
+
+type User struct {
+	Name   string `toml:"name"`
+	Secret string `toml:"secret"`
+	Period uint   `toml:"period"`
+}
+
+const (
+	ACLAllow = "allow"
+	ACLDeny  = "deny"
+)
+
+// ACLRule match when all set fields match, cidr and domain match if any item matches.
+type ACLRule struct {
+	Action string   `toml:"action"` // allow or deny
+	CIDR   []string `toml:"cidr"`
+	Domain []string `toml:"domain"` // domain suffix
+	Ports  string   `toml:"ports"`  // like 80,443,8000-9000
+}
+
+type ACL struct {
+	Default string    `toml:"default"` // allow or deny when no rule match, default is allow
+	Rules   []ACLRule `toml:"rules"`
+}
+
+// Admin is the admin HTTP API, every request need the bearer token.
+type Admin struct {
+	ListenAddr string `toml:"listen_addr"`
+	Token      string `toml:"token"`
+}
+
+// Reverse allow clients to listen Ports on Bind for reverse tunnels.
+type Reverse struct {
+	Bind  string `toml:"bind"`  // listen ip, default is all interfaces
+	Ports string `toml:"ports"` // like 10000-10100, empty disables reverse tunnels
+}
+
+const (
+	PreferIPv4 = "ipv4"
+	PreferIPv6 = "ipv6"
+)
+
+// Outbound is how server connects the targets.
+type Outbound struct {
+	DNS           string   `toml:"dns"`             // DNS server host:port, default is the system resolver
+	DNSTLS        bool     `toml:"dns_tls"`         // use DNS over TLS to dns
+	DNSServerName string   `toml:"dns_server_name"` // verify the DNS over TLS certificate, default is the host of dns
+	DialTimeout   Duration `toml:"dial_timeout"`    // default is 10s
+	BindIP        string   `toml:"bind_ip"`         // source IP, targets of the other IP family are not dialed
+	Interface     string   `toml:"interface"`       // bind to the interface, linux only
+	Prefer        string   `toml:"prefer"`          // ipv4 or ipv6, the other family is the happy eyeballs fallback
+	KeepAlive     Duration `toml:"keep_alive"`      // TCP keepalive period, default is 15s, negative disables
+}
+
 type Config struct {
 	Type             string   `toml:"type"` // support websocket and quic
 	Host             string   `toml:"host"`
@@ -28,6 +87,8 @@ type Config struct {
 	ListenAddr       string   `toml:"listen_addr"`
 	Key              string   `toml:"key"`
 	Crt              string   `toml:"crt"`
+	CertCheck        Duration `toml:"cert_check_interval"` // check certificate files change, default is 30s
+	CertExpiryWarn   Duration `toml:"cert_expiry_warning"` // warn before certificate expiry, default is 336h
 	WebRoot          string   `toml:"web_root"`
 	WebKey           string   `toml:"web_key"`
 	WebCrt           string   `toml:"web_crt"`
@@ -35,11 +96,23 @@ type Config struct {
 	Timeout          Duration `toml:"timeout"`
 	Secret           string   `toml:"secret"`
 	Period           uint     `toml:"period"`
+	Users            []User   `toml:"users"`
+	ClientCA         string   `toml:"client_ca"`   // enable mutual TLS
+	ClientAuth       string   `toml:"client_auth"` // required or optional, default is required
 	ReverseProxyHost string   `toml:"reverse_proxy_host"`
 	ReverseProxyKey  string   `toml:"reverse_proxy_key"`
 	ReverseProxyCrt  string   `toml:"reverse_proxy_crt"`
 	ReverseProxyAddr string   `toml:"reverse_proxy_addr"`
+	Fallback         bool     `toml:"fallback"` // serve failed auth requests of websocket host by web or reverse proxy
+	UDPTimeout       Duration `toml:"udp_timeout"`
+	ACL              ACL      `toml:"acl"`
 	Pprof            string   `toml:"pprof"`
+	Metrics          string   `toml:"metrics"`       // prometheus /metrics listen addr
+	DrainTimeout     Duration `toml:"drain_timeout"` // wait for streams when shutting down, default is 30s
+	AccessLog        string   `toml:"access_log"`    // JSON access log file path or stdout
+	Admin            Admin    `toml:"admin"`         // admin HTTP API (optional)
+	Reverse          Reverse  `toml:"reverse"`       // reverse tunnels (optional)
+	Outbound         Outbound `toml:"outbound"`      // outbound dialer (optional)
 }
 
 type tomlConfig struct {
@@ -52,5 +125,57 @@ func New(path string) (Config, error) {
 		return Config{}, xerrors.Errorf("new server config failed: %w", err)
 	}
 
+	switch config.Server.Type {
+	default:
+		return Config{}, xerrors.Errorf("unknown type %s", config.Server.Type)
+
+	case TypeWebsocket, TypeQuic:
+	}
+
+	if config.Server.ClientCA != "" {
+		if config.Server.Type != TypeWebsocket {
+			return Config{}, xerrors.Errorf("client_ca only support type %s", TypeWebsocket)
+		}
+
+		switch config.Server.ClientAuth {
+		case "":
+			config.Server.ClientAuth = ClientAuthRequired
+
+		case ClientAuthRequired, ClientAuthOptional:
+
+		default:
+			return Config{}, xerrors.Errorf("unknown client_auth %s", config.Server.ClientAuth)
+		}
+	}
+
+	names := make(map[string]bool, len(config.Server.Users))
+
+	for _, user := range config.Server.Users {
+		switch {
+		case user.Name == "" || user.Name == utils.DefaultUser:
+			return Config{}, xerrors.Errorf("invalid user name %q", user.Name)
+
+		case names[user.Name]:
+			return Config{}, xerrors.Errorf("duplicate user %s", user.Name)
+		}
+
+		names[user.Name] = true
+	}
+
+	if config.Server.Admin.ListenAddr != "" && config.Server.Admin.Token == "" {
+		return Config{}, xerrors.New("admin token is required")
+	}
+
+	switch config.Server.Outbound.Prefer {
+	default:
+		return Config{}, xerrors.Errorf("unknown outbound prefer %s", config.Server.Outbound.Prefer)
+
+	case "", PreferIPv4, PreferIPv6:
+	}
+
+	if config.Server.Outbound.DNSTLS && config.Server.Outbound.DNS == "" {
+		return Config{}, xerrors.New("outbound dns_tls need dns")
+	}
+
 	return config.Server, nil
 }
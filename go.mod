@@ -1,6 +1,6 @@
 module github.com/Sherlock-Holo/camouflage
 
-go 1.17
+go 1.23
 
 require (
 	github.com/BurntSushi/toml v1.0.0
@@ -8,18 +8,34 @@ require (
 	github.com/Sherlock-Holo/libsocks v0.1.2
 	github.com/Sherlock-Holo/link v0.6.2-0.20190309121502-1ec20cdbdf62
 	github.com/gorilla/websocket v1.5.0
+	github.com/miekg/dns v1.1.62
 	github.com/pquerna/otp v1.3.0
+	github.com/prometheus/client_golang v1.20.5
+	github.com/quic-go/quic-go v0.54.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/cobra v1.3.0
 	go.uber.org/atomic v1.9.0
+	golang.org/x/sync v0.8.0
+	golang.org/x/sys v0.23.0
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1
 )
 
 require (
+	github.com/beorn7/perks v1.0.1 // indirect
 	github.com/boombuler/barcode v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
 	github.com/inconshreveable/mousetrap v1.0.0 // indirect
+	github.com/klauspost/compress v1.17.9 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/sys v0.3.0 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	go.uber.org/mock v0.5.0 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/mod v0.18.0 // indirect
+	golang.org/x/net v0.28.0 // indirect
+	golang.org/x/tools v0.22.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
 )
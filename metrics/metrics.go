@@ -0,0 +1,152 @@
+// Package metrics holds the prometheus metrics of client and server.
+//
+// Bytes direction is relative to the tunnel: in is read from the session, out is written
+// to the session.
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	errors "golang.org/x/xerrors"
+)
+
+const namespace = "camouflage"
+
+const (
+	DirectionIn  = "in"
+	DirectionOut = "out"
+)
+
+var (
+	ActiveLinks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_links",
+		Help:      "Number of active mux links.",
+	}, []string{"session"})
+
+	StreamsOpened = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "streams_opened_total",
+		Help:      "Number of streams opened by client.",
+	}, []string{"session"})
+
+	StreamsAccepted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "streams_accepted_total",
+		Help:      "Number of streams accepted by server.",
+	}, []string{"session"})
+
+	Bytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_total",
+		Help:      "Number of proxied bytes, in is read from the session, out is written to the session.",
+	}, []string{"direction"})
+
+	DialFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dial_failures_total",
+		Help:      "Number of server dial target failures by error class.",
+	}, []string{"class"})
+
+	AuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Number of client auth failures.",
+	}, []string{"session"})
+
+	AcceptQueueDrops = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accept_queue_drops_total",
+		Help:      "Number of streams dropped because the accept queue is full.",
+	}, []string{"session"})
+
+	DialQueueDrops = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dial_queue_drops_total",
+		Help:      "Number of connections dropped because the client dial queue is full.",
+	})
+
+	HandshakeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "handshake_duration_seconds",
+		Help:      "Latency of client connect and auth handshake.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"session"})
+)
+
+// serveMux serve /metrics and the handlers registered by Handle.
+var serveMux = newServeMux()
+
+func newServeMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	return mux
+}
+
+// Handle register handler of pattern on the metrics listener.
+func Handle(pattern string, handler http.Handler) {
+	serveMux.Handle(pattern, handler)
+}
+
+// Serve serve /metrics and the registered handlers on addr.
+func Serve(addr string) error {
+	if err := http.ListenAndServe(addr, serveMux); err != nil {
+		return errors.Errorf("serve metrics failed: %w", err)
+	}
+
+	return nil
+}
+
+// DialErrorClass classify the dial error for DialFailures.
+func DialErrorClass(err error) string {
+	var (
+		dnsErr *net.DNSError
+		netErr net.Error
+	)
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return "dns"
+
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return "unreachable"
+
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+
+	default:
+		return "other"
+	}
+}
+
+type countWriter struct {
+	io.Writer
+
+	counter prometheus.Counter
+}
+
+func (c countWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.counter.Add(float64(n))
+
+	return n, err
+}
+
+// CountWriter count the bytes written to w in Bytes with direction.
+func CountWriter(w io.Writer, direction string) io.Writer {
+	return countWriter{
+		Writer:  w,
+		counter: Bytes.WithLabelValues(direction),
+	}
+}
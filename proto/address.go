@@ -0,0 +1,45 @@
+package proto
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/Sherlock-Holo/libsocks"
+	errors "golang.org/x/xerrors"
+)
+
+// ParseAddress convert host:port to a socks address.
+func ParseAddress(hostport string) (libsocks.Address, error) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return libsocks.Address{}, errors.Errorf("split host port failed: %w", err)
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return libsocks.Address{}, errors.Errorf("parse port %s failed: %w", portStr, err)
+	}
+
+	address := libsocks.Address{Port: uint16(port)}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return libsocks.Address{}, errors.Errorf("domain %s too long", host)
+		}
+
+		address.Type = libsocks.TypeDomain
+		address.Host = host
+
+	case ip.To4() != nil:
+		address.Type = libsocks.TypeIPv4
+		address.IP = ip.To4()
+
+	default:
+		address.Type = libsocks.TypeIPv6
+		address.IP = ip.To16()
+	}
+
+	return address, nil
+}
@@ -0,0 +1,54 @@
+package proto
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/Sherlock-Holo/libsocks"
+	errors "golang.org/x/xerrors"
+)
+
+// MaxDatagramSize is the max UDP payload size.
+const MaxDatagramSize = 65535
+
+// WriteDatagram write a UDP datagram to w.
+//
+// [address | payload length 2 bytes | payload]
+func WriteDatagram(w io.Writer, address libsocks.Address, payload []byte) error {
+	if len(payload) > MaxDatagramSize {
+		return errors.Errorf("datagram too large: %d", len(payload))
+	}
+
+	addr := address.Bytes()
+
+	b := make([]byte, len(addr)+2+len(payload))
+	copy(b, addr)
+	binary.BigEndian.PutUint16(b[len(addr):], uint16(len(payload)))
+	copy(b[len(addr)+2:], payload)
+
+	if _, err := w.Write(b); err != nil {
+		return errors.Errorf("write datagram failed: %w", err)
+	}
+
+	return nil
+}
+
+// ReadDatagram read a UDP datagram written by WriteDatagram from r.
+func ReadDatagram(r io.Reader) (libsocks.Address, []byte, error) {
+	address, err := libsocks.UnmarshalAddressFrom(r)
+	if err != nil {
+		return libsocks.Address{}, nil, errors.Errorf("read datagram address failed: %w", err)
+	}
+
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return libsocks.Address{}, nil, errors.Errorf("read datagram length failed: %w", err)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return libsocks.Address{}, nil, errors.Errorf("read datagram payload failed: %w", err)
+	}
+
+	return address, payload, nil
+}
@@ -0,0 +1,52 @@
+// Package proto defines what a camouflage stream carries after it is opened.
+//
+// A stream starts with a header. If the first byte is a socks address type,
+// the header is a socks address and the stream is a TCP connect to it, this
+// keeps old clients working. Otherwise the first byte is a command.
+package proto
+
+import (
+	"github.com/Sherlock-Holo/libsocks"
+)
+
+const (
+	// CmdUDPAssociate stream carries framed UDP datagrams, see WriteDatagram.
+	CmdUDPAssociate byte = 0x80
+
+	// CmdPing stream is a health check, server reply PingReply and close the stream.
+	CmdPing byte = 0x81
+
+	// CmdReverseListen stream registers a reverse tunnel, client send the remote port in 2
+	// bytes, server reply ReverseOK or ReverseFailed, then send a ReverseTokenSize bytes token
+	// for every inbound connection. Server close the listener when the stream is closed.
+	CmdReverseListen byte = 0x82
+
+	// CmdReverseAccept stream carries an inbound connection of a reverse tunnel, client send
+	// the token received on the listen stream after the command.
+	CmdReverseAccept byte = 0x83
+
+	// CmdDNS stream carries a DNS query, client send the query prefixed with its length in 2
+	// bytes like DNS over TCP, server reply the answer of its resolver in the same way and
+	// close the stream.
+	CmdDNS byte = 0x84
+)
+
+const (
+	PingReply byte = 0
+
+	ReverseOK     byte = 0
+	ReverseFailed byte = 1
+
+	ReverseTokenSize = 16
+)
+
+// IsAddressType report if b is a socks address type, which means a TCP connect stream.
+func IsAddressType(b byte) bool {
+	switch b {
+	case libsocks.TypeIPv4, libsocks.TypeIPv6, libsocks.TypeDomain:
+		return true
+
+	default:
+		return false
+	}
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"net"
+	"strings"
+
+	config "github.com/Sherlock-Holo/camouflage/config/server"
+	"github.com/Sherlock-Holo/camouflage/utils"
+	errors "golang.org/x/xerrors"
+)
+
+// defaultDenyNets are denied unless allowed by a rule, they are usually not a proxy target
+// but the server itself, its LAN or cloud metadata services.
+var defaultDenyNets = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10", // carrier-grade NAT, some clouds put metadata here
+	"::/128",
+	"64:ff9b:1::/48", // local-use NAT64, the embedded IPv4 position depends on the prefix length
+)
+
+// nat64Net is the well-known NAT64 prefix, its last 32 bits are the IPv4 address.
+var nat64Net = mustParseCIDRs("64:ff9b::/96")[0]
+
+type aclRule struct {
+	allow   bool
+	nets    []*net.IPNet
+	domains []string
+	ports   utils.PortRanges
+}
+
+func (r *aclRule) match(domain string, ip net.IP, port uint16) bool {
+	if len(r.nets) > 0 && !containsIP(r.nets, ip) {
+		return false
+	}
+
+	if len(r.domains) > 0 {
+		if domain == "" {
+			return false
+		}
+
+		var matched bool
+		for _, suffix := range r.domains {
+			if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	if len(r.ports) > 0 && !r.ports.Contains(port) {
+		return false
+	}
+
+	return true
+}
+
+// acl check the proxy target after DNS resolution, rules are checked in order and the first
+// matched rule decides. Loopback, link-local and private addresses are denied unless allowed
+// by a rule with CIDR containing them, others follow the default action if no rule matches.
+type acl struct {
+	rules        []aclRule
+	defaultAllow bool
+}
+
+func newACL(cfg config.ACL) (*acl, error) {
+	a := &acl{defaultAllow: true}
+
+	switch cfg.Default {
+	case "", config.ACLAllow:
+	case config.ACLDeny:
+		a.defaultAllow = false
+
+	default:
+		return nil, errors.Errorf("unknown acl default action %s", cfg.Default)
+	}
+
+	for i, ruleCfg := range cfg.Rules {
+		var rule aclRule
+
+		switch ruleCfg.Action {
+		case config.ACLAllow:
+			rule.allow = true
+
+		case config.ACLDeny:
+
+		default:
+			return nil, errors.Errorf("acl rule %d unknown action %s", i, ruleCfg.Action)
+		}
+
+		for _, cidr := range ruleCfg.CIDR {
+			_, ipNet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, errors.Errorf("acl rule %d parse cidr failed: %w", i, err)
+			}
+
+			rule.nets = append(rule.nets, ipNet)
+		}
+
+		for _, domain := range ruleCfg.Domain {
+			rule.domains = append(rule.domains, strings.ToLower(strings.Trim(domain, ".")))
+		}
+
+		ports, err := utils.ParsePortRanges(ruleCfg.Ports)
+		if err != nil {
+			return nil, errors.Errorf("acl rule %d parse ports failed: %w", i, err)
+		}
+
+		rule.ports = ports
+
+		a.rules = append(a.rules, rule)
+	}
+
+	return a, nil
+}
+
+// allowed report if the target is allowed, domain is empty when the target is an IP.
+func (a *acl) allowed(domain string, ip net.IP, port uint16) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	internal := isInternalIP(ip)
+
+	for i := range a.rules {
+		rule := &a.rules[i]
+
+		// a port or domain rule can't expose internal addresses, the domain may resolve to them
+		if internal && rule.allow && len(rule.nets) == 0 {
+			continue
+		}
+
+		if rule.match(domain, ip, port) {
+			return rule.allow
+		}
+	}
+
+	if internal {
+		return false
+	}
+
+	return a.defaultAllow
+}
+
+func isInternalIP(ip net.IP) bool {
+	// 64:ff9b::a9fe:a9fe reaches 169.254.169.254 on NAT64 networks
+	if ip.To4() == nil && nat64Net.Contains(ip) {
+		return isInternalIP(ip[net.IPv6len-net.IPv4len:])
+	}
+
+	return ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsUnspecified() ||
+		containsIP(defaultDenyNets, ip)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		nets = append(nets, ipNet)
+	}
+
+	return nets
+}
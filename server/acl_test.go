@@ -0,0 +1,147 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	config "github.com/Sherlock-Holo/camouflage/config/server"
+)
+
+func TestIsInternalIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		internal bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"0.1.2.3", true},
+		{"::1", true},
+		{"::", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b::7f00:1", true},
+		{"64:ff9b:1::1", true},
+		{"8.8.8.8", false},
+		{"1.1.1.1", false},
+		{"2001:4860:4860::8888", false},
+		{"64:ff9b::808:808", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if internal := isInternalIP(net.ParseIP(tt.ip)); internal != tt.internal {
+				t.Errorf("isInternalIP(%s) = %v, want %v", tt.ip, internal, tt.internal)
+			}
+		})
+	}
+}
+
+func TestACLAllowed(t *testing.T) {
+	cfg := config.ACL{
+		Default: config.ACLAllow,
+		Rules: []config.ACLRule{
+			{Action: config.ACLDeny, Ports: "25"},
+			{Action: config.ACLAllow, CIDR: []string{"10.1.0.0/16"}, Ports: "80,443"},
+			{Action: config.ACLAllow, Domain: []string{"corp.example.com"}},
+			{Action: config.ACLDeny, Domain: []string{"blocked.example.com"}},
+			{Action: config.ACLDeny, CIDR: []string{"203.0.113.0/24"}},
+		},
+	}
+
+	a, err := newACL(cfg)
+	if err != nil {
+		t.Fatalf("newACL failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		domain  string
+		ip      string
+		port    uint16
+		allowed bool
+	}{
+		{"public ip by default", "", "8.8.8.8", 443, true},
+		{"denied port", "", "8.8.8.8", 25, false},
+		{"deny rule before allow cidr", "", "10.1.0.1", 25, false},
+		{"internal ip allowed by cidr", "", "10.1.0.1", 443, true},
+		{"internal ip out of cidr ports", "", "10.1.0.1", 22, false},
+		{"internal ip not in cidr", "", "10.2.0.1", 443, false},
+		{"loopback", "", "127.0.0.1", 80, false},
+		{"metadata", "", "169.254.169.254", 80, false},
+		{"nat64 metadata", "", "64:ff9b::a9fe:a9fe", 80, false},
+		{"allowed domain resolved to public ip", "corp.example.com", "8.8.4.4", 80, true},
+		{"allowed domain can't expose internal ip", "corp.example.com", "192.168.1.1", 80, false},
+		{"allowed subdomain can't expose loopback", "git.corp.example.com", "127.0.0.1", 80, false},
+		{"domain rule is checked before ip rule", "blocked.example.com", "8.8.8.8", 80, false},
+		{"domain case and trailing dot", "Blocked.Example.COM.", "8.8.8.8", 80, false},
+		{"domain suffix needs a dot", "notblocked.example.com", "8.8.8.8", 80, true},
+		{"denied cidr", "", "203.0.113.1", 80, false},
+		{"denied cidr by domain", "example.org", "203.0.113.1", 80, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := a.allowed(tt.domain, net.ParseIP(tt.ip), tt.port); allowed != tt.allowed {
+				t.Errorf("allowed(%q, %s, %d) = %v, want %v", tt.domain, tt.ip, tt.port, allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestACLDefaultDeny(t *testing.T) {
+	a, err := newACL(config.ACL{
+		Default: config.ACLDeny,
+		Rules: []config.ACLRule{
+			{Action: config.ACLAllow, Ports: "443"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("newACL failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		ip      string
+		port    uint16
+		allowed bool
+	}{
+		{"allowed port", "8.8.8.8", 443, true},
+		{"other port", "8.8.8.8", 80, false},
+		{"port rule can't expose internal ip", "192.168.1.1", 443, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if allowed := a.allowed("", net.ParseIP(tt.ip), tt.port); allowed != tt.allowed {
+				t.Errorf("allowed(%s, %d) = %v, want %v", tt.ip, tt.port, allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestNewACLInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.ACL
+	}{
+		{"unknown default", config.ACL{Default: "maybe"}},
+		{"unknown action", config.ACL{Rules: []config.ACLRule{{Action: "maybe"}}}},
+		{"invalid cidr", config.ACL{Rules: []config.ACLRule{{Action: config.ACLDeny, CIDR: []string{"10.0.0.0/33"}}}}},
+		{"invalid ports", config.ACL{Rules: []config.ACLRule{{Action: config.ACLDeny, Ports: "90-80"}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newACL(tt.cfg); err == nil {
+				t.Error("newACL succeeded, want error")
+			}
+		})
+	}
+}
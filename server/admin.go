@@ -0,0 +1,214 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/accesslog"
+	"github.com/Sherlock-Holo/camouflage/session"
+	"github.com/Sherlock-Holo/libsocks"
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+// target is an active proxied connection, its bytes are counted by the access log stream.
+type target struct {
+	id      uint64
+	link    uint64
+	user    string
+	source  string
+	address string
+	started time.Time
+	stream  *accesslog.Stream
+}
+
+type targetInfo struct {
+	ID       uint64    `json:"id"`
+	Link     uint64    `json:"link"`
+	User     string    `json:"user"`
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	Started  time.Time `json:"started"`
+	BytesIn  uint64    `json:"bytes_in"`
+	BytesOut uint64    `json:"bytes_out"`
+}
+
+func (t *target) info() targetInfo {
+	return targetInfo{
+		ID:       t.id,
+		Link:     t.link,
+		User:     t.user,
+		Source:   t.source,
+		Target:   t.address,
+		Started:  t.started,
+		BytesIn:  t.stream.Sent.Load(),
+		BytesOut: t.stream.Received.Load(),
+	}
+}
+
+func (s *Server) addTarget(id uint64, conn net.Conn, address libsocks.Address, stream *accesslog.Stream) {
+	t := &target{
+		id:      id,
+		user:    session.User(conn),
+		source:  streamSource(conn),
+		address: address.String(),
+		started: time.Now(),
+		stream:  stream,
+	}
+
+	if clientLink, ok := session.LinkOf(conn); ok {
+		t.link = clientLink.ID()
+	}
+
+	s.targets.Store(t.id, t)
+}
+
+// streamSource return the client address of a stream, the remote addr of a link stream may be
+// the stream id, so the link remote is preferred.
+func streamSource(conn net.Conn) string {
+	if clientLink, ok := session.LinkOf(conn); ok {
+		return clientLink.Remote()
+	}
+
+	return conn.RemoteAddr().String()
+}
+
+// allSessions return the current session and the old ones which may still have links.
+func (s *Server) allSessions() []session.Server {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	return append([]session.Server(nil), s.sessions...)
+}
+
+// serveAdmin serve the admin HTTP API:
+//
+//	GET    /api/links               list links, filter by ?user=
+//	DELETE /api/links/{id}          close a link
+//	DELETE /api/users/{user}/links  close all links of a user
+//	GET    /api/targets             list active proxied targets
+func (s *Server) serveAdmin(addr, token string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Errorf("admin listen failed: %w", err)
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /api/links", s.adminListLinks)
+	mux.HandleFunc("DELETE /api/links/{id}", s.adminCloseLink)
+	mux.HandleFunc("DELETE /api/users/{user}/links", s.adminCloseUserLinks)
+	mux.HandleFunc("GET /api/targets", s.adminListTargets)
+
+	s.admin = &http.Server{Handler: adminAuth(token, mux)}
+
+	go func() {
+		if err := s.admin.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			err = errors.Errorf("admin api serve failed: %w", err)
+			log.Errorf("%+v", err)
+		}
+	}()
+
+	log.Infof("admin api listen on %s", addr)
+
+	return nil
+}
+
+// adminAuth reject the requests without the bearer token.
+func adminAuth(token string, handler http.Handler) http.Handler {
+	expect := []byte("Bearer " + token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expect) != 1 {
+			log.Warnf("admin api unauthorized request from %s", r.RemoteAddr)
+
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) adminListLinks(w http.ResponseWriter, r *http.Request) {
+	user := r.URL.Query().Get("user")
+
+	links := []session.LinkInfo{}
+
+	for _, sess := range s.allSessions() {
+		for _, info := range sess.Links() {
+			if user == "" || info.User == user {
+				links = append(links, info)
+			}
+		}
+	}
+
+	writeJSON(w, links)
+}
+
+func (s *Server) adminCloseLink(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid link id", http.StatusBadRequest)
+
+		return
+	}
+
+	for _, sess := range s.allSessions() {
+		if sess.CloseLink(id) {
+			log.Infof("admin api close link %d", id)
+
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+	}
+
+	http.Error(w, "link not found", http.StatusNotFound)
+}
+
+func (s *Server) adminCloseUserLinks(w http.ResponseWriter, r *http.Request) {
+	user := r.PathValue("user")
+
+	closed := []uint64{}
+
+	for _, sess := range s.allSessions() {
+		for _, info := range sess.Links() {
+			if info.User == user && sess.CloseLink(info.ID) {
+				closed = append(closed, info.ID)
+			}
+		}
+	}
+
+	log.Infof("admin api close %d links of user %s", len(closed), user)
+
+	writeJSON(w, struct {
+		Closed []uint64 `json:"closed"`
+	}{Closed: closed})
+}
+
+func (s *Server) adminListTargets(w http.ResponseWriter, _ *http.Request) {
+	targets := []targetInfo{}
+
+	s.targets.Range(func(_, value interface{}) bool {
+		targets = append(targets, value.(*target).info())
+
+		return true
+	})
+
+	writeJSON(w, targets)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		err = errors.Errorf("admin api write response failed: %w", err)
+		log.Debugf("%v", err)
+	}
+}
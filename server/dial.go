@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net"
+
+	"github.com/Sherlock-Holo/libsocks"
+	errors "golang.org/x/xerrors"
+)
+
+var errDenied = errors.New("denied by acl")
+
+// resolve resolve the target address by the outbound resolver, return the IPs allowed by acl and
+// usable by the outbound bind ip, the preferred IP family first. The caller must use the returned
+// IPs, so DNS can't bypass the acl by returning another IP later.
+func (s *Server) resolve(ctx context.Context, address libsocks.Address) ([]net.IP, error) {
+	out := s.outbound.Load().(*outbound)
+
+	var (
+		domain string
+		ips    []net.IP
+	)
+
+	switch address.Type {
+	case libsocks.TypeDomain:
+		domain = address.Host
+
+		ipAddrs, err := out.resolver.LookupIPAddr(ctx, address.Host)
+		if err != nil {
+			return nil, errors.Errorf("resolve %s failed: %w", address.Host, err)
+		}
+
+		for _, ipAddr := range ipAddrs {
+			ips = append(ips, ipAddr.IP)
+		}
+
+	default:
+		ips = []net.IP{address.IP}
+	}
+
+	egressACL := s.acl.Load().(*acl)
+
+	allowed := ips[:0]
+	for _, ip := range ips {
+		if egressACL.allowed(domain, ip, address.Port) {
+			allowed = append(allowed, ip)
+		}
+	}
+
+	if len(allowed) == 0 {
+		return nil, errors.Errorf("target %s %v: %w", address, ips, errDenied)
+	}
+
+	usable := make([]net.IP, 0, len(allowed))
+	for _, ip := range allowed {
+		if out.usable(ip) {
+			usable = append(usable, ip)
+		}
+	}
+
+	if len(usable) == 0 {
+		return nil, errors.Errorf("target %s %v has no ip of the outbound bind ip family", address, allowed)
+	}
+
+	return out.sortIPs(usable), nil
+}
+
+// dial connect the target address by the IPs allowed by acl, in the dial timeout of outbound.
+func (s *Server) dial(ctx context.Context, address libsocks.Address) (net.Conn, error) {
+	out := s.outbound.Load().(*outbound)
+
+	ctx, cancel := context.WithTimeout(ctx, out.timeout)
+	defer cancel()
+
+	ips, err := s.resolve(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := out.dial(ctx, ips, address.Port)
+	if err != nil {
+		return nil, errors.Errorf("dial %s failed: %w", address, err)
+	}
+
+	return conn, nil
+}
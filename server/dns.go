@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/session"
+	"github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+const (
+	resolvConf = "/etc/resolv.conf"
+
+	dnsTimeout = 5 * time.Second
+)
+
+// loadNameservers return the nameservers of resolv.conf, localhost is used when it can't be read
+// like the go resolver.
+func loadNameservers() []string {
+	clientConfig, err := dns.ClientConfigFromFile(resolvConf)
+	if err != nil || len(clientConfig.Servers) == 0 {
+		log.Warnf("read nameservers from %s failed, use localhost: %v", resolvConf, err)
+
+		return []string{"127.0.0.1:53", "[::1]:53"}
+	}
+
+	nameservers := make([]string, 0, len(clientConfig.Servers))
+	for _, server := range clientConfig.Servers {
+		nameservers = append(nameservers, net.JoinHostPort(server, clientConfig.Port))
+	}
+
+	return nameservers
+}
+
+// handleDNS answer the DNS query on conn by the outbound nameservers.
+func (s *Server) handleDNS(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	dnsConn := &dns.Conn{Conn: conn}
+
+	query, err := dnsConn.ReadMsg()
+	if err != nil {
+		err = errors.Errorf("read dns query failed: %w", err)
+		log.Errorf("%+v", err)
+
+		return
+	}
+
+	if len(query.Question) > 0 {
+		log.Debugf("user %s dns query %s", session.User(conn), query.Question[0].String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+
+	answer, err := s.exchangeDNS(ctx, query)
+	if err != nil {
+		err = errors.Errorf("user %s dns query failed: %w", session.User(conn), err)
+		log.Warnf("%v", err)
+
+		answer = new(dns.Msg).SetRcode(query, dns.RcodeServerFailure)
+	}
+
+	if err := dnsConn.WriteMsg(answer); err != nil {
+		err = errors.Errorf("write dns answer failed: %w", err)
+		log.Errorf("%+v", err)
+	}
+}
+
+// exchangeDNS send query to the outbound nameservers in order until one of them answers, a
+// truncated UDP answer is retried over TCP.
+func (s *Server) exchangeDNS(ctx context.Context, query *dns.Msg) (*dns.Msg, error) {
+	out := s.outbound.Load().(*outbound)
+
+	dnsClient := &dns.Client{Dialer: out.dnsDialer("udp")}
+	if out.dnsTLS != nil {
+		dnsClient = &dns.Client{Net: "tcp-tls", TLSConfig: out.dnsTLS, Dialer: out.dnsDialer("tcp")}
+	}
+
+	var err error
+
+	for _, nameserver := range out.nameservers {
+		var answer *dns.Msg
+
+		answer, _, err = dnsClient.ExchangeContext(ctx, query, nameserver)
+		if err == nil && answer.Truncated {
+			answer, _, err = (&dns.Client{Net: "tcp", Dialer: out.dnsDialer("tcp")}).ExchangeContext(ctx, query, nameserver)
+		}
+
+		if err == nil {
+			return answer, nil
+		}
+	}
+
+	return nil, errors.Errorf("exchange dns failed: %w", err)
+}
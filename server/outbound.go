@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"time"
+
+	config "github.com/Sherlock-Holo/camouflage/config/server"
+	errors "golang.org/x/xerrors"
+)
+
+const (
+	defaultDialTimeout = 10 * time.Second
+
+	// fallbackDelay is how long the first IP family is dialed alone before the other one starts,
+	// like net.Dialer.
+	fallbackDelay = 300 * time.Millisecond
+)
+
+// outbound resolve and connect the targets, it is replaced by reload.
+type outbound struct {
+	resolver     *net.Resolver
+	dialer       net.Dialer
+	listenConfig net.ListenConfig
+	bindIP       net.IP
+	prefer       string
+	timeout      time.Duration
+
+	// nameservers answer the DNS streams, dnsTLS is set when they are DNS over TLS
+	nameservers []string
+	dnsTLS      *tls.Config
+}
+
+func newOutbound(cfg config.Outbound) (*outbound, error) {
+	o := &outbound{
+		resolver: net.DefaultResolver,
+		dialer:   net.Dialer{KeepAlive: cfg.KeepAlive.Duration},
+		prefer:   cfg.Prefer,
+		timeout:  cfg.DialTimeout.Duration,
+	}
+
+	if o.timeout <= 0 {
+		o.timeout = defaultDialTimeout
+	}
+
+	if cfg.BindIP != "" {
+		o.bindIP = net.ParseIP(cfg.BindIP)
+		if o.bindIP == nil {
+			return nil, errors.Errorf("invalid outbound bind ip %s", cfg.BindIP)
+		}
+
+		o.dialer.LocalAddr = &net.TCPAddr{IP: o.bindIP}
+	}
+
+	if cfg.Interface != "" {
+		if _, err := net.InterfaceByName(cfg.Interface); err != nil {
+			return nil, errors.Errorf("outbound interface %s failed: %w", cfg.Interface, err)
+		}
+
+		control, err := bindInterface(cfg.Interface)
+		if err != nil {
+			return nil, err
+		}
+
+		o.dialer.Control = control
+		o.listenConfig.Control = control
+	}
+
+	if cfg.DNS == "" {
+		o.nameservers = loadNameservers()
+
+		// the nameservers of the default resolver are also dialed from the bind ip and interface
+		if o.bindIP != nil || o.dialer.Control != nil {
+			o.resolver = &net.Resolver{
+				PreferGo: true,
+				Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+					return o.dnsDialer(network).DialContext(ctx, network, address)
+				},
+			}
+		}
+
+		return o, nil
+	}
+
+	host, _, err := net.SplitHostPort(cfg.DNS)
+	if err != nil {
+		return nil, errors.Errorf("invalid outbound dns %s: %w", cfg.DNS, err)
+	}
+
+	o.nameservers = []string{cfg.DNS}
+
+	if cfg.DNSTLS {
+		serverName := cfg.DNSServerName
+		if serverName == "" {
+			serverName = host
+		}
+
+		o.dnsTLS = &tls.Config{ServerName: serverName}
+	}
+
+	o.resolver = &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			if o.dnsTLS != nil {
+				tlsDialer := &tls.Dialer{NetDialer: o.dnsDialer("tcp"), Config: o.dnsTLS}
+
+				return tlsDialer.DialContext(ctx, "tcp", cfg.DNS)
+			}
+
+			return o.dnsDialer(network).DialContext(ctx, network, cfg.DNS)
+		},
+	}
+
+	return o, nil
+}
+
+// dnsDialer return the dialer of the nameservers, it dials from the bind ip and interface like
+// the target dialer.
+func (o *outbound) dnsDialer(network string) *net.Dialer {
+	dialer := &net.Dialer{
+		Timeout: dnsTimeout,
+		Control: o.dialer.Control,
+	}
+
+	if o.bindIP != nil {
+		switch network {
+		case "udp", "udp4", "udp6":
+			dialer.LocalAddr = &net.UDPAddr{IP: o.bindIP}
+
+		default:
+			dialer.LocalAddr = &net.TCPAddr{IP: o.bindIP}
+		}
+	}
+
+	return dialer
+}
+
+// usable report if ip can be dialed from the bind ip.
+func (o *outbound) usable(ip net.IP) bool {
+	if o.bindIP == nil || o.bindIP.IsUnspecified() {
+		return true
+	}
+
+	return (o.bindIP.To4() != nil) == (ip.To4() != nil)
+}
+
+// sortIPs move the IPs of the preferred family to the front, the order in a family is kept.
+func (o *outbound) sortIPs(ips []net.IP) []net.IP {
+	if o.prefer == "" {
+		return ips
+	}
+
+	preferIPv4 := o.prefer == config.PreferIPv4
+
+	sorted := make([]net.IP, 0, len(ips))
+
+	for _, ip := range ips {
+		if (ip.To4() != nil) == preferIPv4 {
+			sorted = append(sorted, ip)
+		}
+	}
+
+	for _, ip := range ips {
+		if (ip.To4() != nil) != preferIPv4 {
+			sorted = append(sorted, ip)
+		}
+	}
+
+	return sorted
+}
+
+// listenUDP listen an UDP socket on the bind ip and interface.
+func (o *outbound) listenUDP(ctx context.Context) (*net.UDPConn, error) {
+	var addr string
+
+	if o.bindIP != nil {
+		addr = net.JoinHostPort(o.bindIP.String(), "0")
+	}
+
+	packetConn, err := o.listenConfig.ListenPacket(ctx, "udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return packetConn.(*net.UDPConn), nil
+}
+
+// dial connect port of ips by happy eyeballs, the IPs of the first IP family are the primaries,
+// the others are the fallbacks.
+func (o *outbound) dial(ctx context.Context, ips []net.IP, port uint16) (net.Conn, error) {
+	var primaries, fallbacks []string
+
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+
+		if (ip.To4() != nil) == (ips[0].To4() != nil) {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+
+	if len(fallbacks) == 0 {
+		return o.dialSerial(ctx, primaries)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialResult struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+
+	results := make(chan dialResult)
+
+	start := func(addrs []string, primary bool) {
+		go func() {
+			conn, err := o.dialSerial(ctx, addrs)
+
+			select {
+			case results <- dialResult{conn: conn, err: err, primary: primary}:
+
+			case <-ctx.Done():
+				if conn != nil {
+					_ = conn.Close()
+				}
+			}
+		}()
+	}
+
+	start(primaries, true)
+
+	fallbackTimer := time.NewTimer(fallbackDelay)
+	defer fallbackTimer.Stop()
+
+	var (
+		fallbackStarted bool
+		primaryErr      error
+		fallbackErr     error
+	)
+
+	for {
+		select {
+		case <-fallbackTimer.C:
+			if !fallbackStarted {
+				fallbackStarted = true
+				start(fallbacks, false)
+			}
+
+		case result := <-results:
+			if result.err == nil {
+				return result.conn, nil
+			}
+
+			if result.primary {
+				primaryErr = result.err
+			} else {
+				fallbackErr = result.err
+			}
+
+			if primaryErr != nil && fallbackErr != nil {
+				return nil, primaryErr
+			}
+
+			// the primaries failed before the fallback delay
+			if !fallbackStarted {
+				fallbackStarted = true
+				start(fallbacks, false)
+			}
+		}
+	}
+}
+
+// dialSerial connect addrs in order until one of them is connected.
+func (o *outbound) dialSerial(ctx context.Context, addrs []string) (net.Conn, error) {
+	var err error
+
+	for _, addr := range addrs {
+		var conn net.Conn
+
+		conn, err = o.dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn, nil
+		}
+	}
+
+	return nil, err
+}
@@ -0,0 +1,29 @@
+//go:build linux
+
+package server
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	errors "golang.org/x/xerrors"
+)
+
+// bindInterface return the socket control which bind the socket to the interface name.
+func bindInterface(name string) (func(network, address string, rawConn syscall.RawConn) error, error) {
+	return func(_, _ string, rawConn syscall.RawConn) error {
+		var sockErr error
+
+		if err := rawConn.Control(func(fd uintptr) {
+			sockErr = unix.BindToDevice(int(fd), name)
+		}); err != nil {
+			return err
+		}
+
+		if sockErr != nil {
+			return errors.Errorf("bind to interface %s failed: %w", name, sockErr)
+		}
+
+		return nil
+	}, nil
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package server
+
+import (
+	"syscall"
+
+	errors "golang.org/x/xerrors"
+)
+
+func bindInterface(string) (func(network, address string, rawConn syscall.RawConn) error, error) {
+	return nil, errors.New("outbound interface is only supported on linux")
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"slices"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/certstore"
+	config "github.com/Sherlock-Holo/camouflage/config/server"
+	"github.com/Sherlock-Holo/camouflage/session"
+	"github.com/Sherlock-Holo/camouflage/utils"
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+// retireCheckInterval is how often the session replaced by reload is checked for links.
+const retireCheckInterval = time.Second
+
+func (s *Server) setUDPTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultUDPTimeout
+	}
+
+	s.udpTimeout.Store(timeout)
+}
+
+// Reload apply cfg, the session is rebound only if the listen addr or type changed, the old
+// session stops listening but its links keep working until they are closed. Users, certificates,
+// acl, reverse ports, udp timeout and outbound dialer are swapped atomically, registered reverse
+// tunnels are kept.
+// If any part of cfg fails, nothing is changed.
+func (s *Server) Reload(cfg *config.Config) error {
+	egressACL, err := newACL(cfg.ACL)
+	if err != nil {
+		return errors.Errorf("new acl failed: %w", err)
+	}
+
+	reverse, err := newReverseConfig(cfg.Reverse)
+	if err != nil {
+		return err
+	}
+
+	out, err := newOutbound(cfg.Outbound)
+	if err != nil {
+		return errors.Errorf("new outbound failed: %w", err)
+	}
+
+	certs, err := newCertStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	if s.closed.Load() {
+		_ = certs.Close()
+
+		return errors.New("server is closed")
+	}
+
+	if cfg.ListenAddr != s.cfg.ListenAddr || cfg.Type != s.cfg.Type {
+		sess, err := newSession(cfg, s.getCertificate)
+		if err != nil {
+			_ = certs.Close()
+
+			return err
+		}
+
+		old := s.session
+		s.session = sess
+		s.sessions = append(s.sessions, sess)
+
+		go s.serve(sess)
+
+		if err := old.CloseListener(); err != nil {
+			err = errors.Errorf("close old listener failed: %w", err)
+			log.Warnf("%+v", err)
+		}
+
+		go s.retire(old)
+
+		log.Infof("rebind %s server to %s", cfg.Type, cfg.ListenAddr)
+	} else {
+		if needRestart(&s.cfg, cfg) {
+			log.Warn("host, path, timeout, fallback, client ca, web and reverse proxy change need restart")
+		}
+
+		s.session.SetUsers(users(cfg)...)
+	}
+
+	oldCerts := s.certs.Swap(certs).(*certstore.Store)
+	_ = oldCerts.Close()
+
+	s.acl.Store(egressACL)
+	s.reverse.Store(reverse)
+	s.setUDPTimeout(cfg.UDPTimeout.Duration)
+	s.outbound.Store(out)
+
+	if cfg.Admin != s.cfg.Admin {
+		log.Warn("admin api change need restart")
+	}
+
+	if cfg.AccessLog != s.cfg.AccessLog {
+		log.Warn("access log change need restart")
+	}
+
+	if cfg.Metrics != s.cfg.Metrics || cfg.Pprof != s.cfg.Pprof {
+		log.Warn("metrics and pprof listen addr change need restart")
+	}
+
+	s.cfg = *cfg
+
+	return nil
+}
+
+// retire wait until the last link of sess replaced by reload is closed, then close sess and
+// remove it from sessions, its serve goroutine returns.
+func (s *Server) retire(sess session.Server) {
+	ticker := time.NewTicker(retireCheckInterval)
+	defer ticker.Stop()
+
+	for len(sess.Links()) > 0 {
+		<-ticker.C
+
+		if s.closed.Load() {
+			return
+		}
+	}
+
+	s.reloadMutex.Lock()
+
+	// closed by shutdown, it closes all sessions
+	if s.closed.Load() {
+		s.reloadMutex.Unlock()
+
+		return
+	}
+
+	s.sessions = slices.DeleteFunc(s.sessions, func(other session.Server) bool {
+		return other == sess
+	})
+
+	s.reloadMutex.Unlock()
+
+	_ = sess.Close()
+
+	log.Infof("old %s server has no link, closed", sess.Name())
+}
+
+// isRetired report if sess is replaced by reload and closed.
+func (s *Server) isRetired(sess session.Server) bool {
+	s.reloadMutex.Lock()
+	defer s.reloadMutex.Unlock()
+
+	return !slices.Contains(s.sessions, sess)
+}
+
+// users return the TOTP users of cfg, the top level secret is the default user.
+func users(cfg *config.Config) []utils.User {
+	var list []utils.User
+
+	if cfg.Secret != "" {
+		list = append(list, utils.User{
+			Name:   utils.DefaultUser,
+			Secret: cfg.Secret,
+			Period: cfg.Period,
+		})
+	}
+
+	for _, user := range cfg.Users {
+		list = append(list, utils.User{
+			Name:   user.Name,
+			Secret: user.Secret,
+			Period: user.Period,
+		})
+	}
+
+	return list
+}
+
+// needRestart report if the session settings which can't be reloaded are changed.
+func needRestart(old, cfg *config.Config) bool {
+	return old.Host != cfg.Host ||
+		old.Path != cfg.Path ||
+		old.Timeout != cfg.Timeout ||
+		old.Fallback != cfg.Fallback ||
+		old.ClientCA != cfg.ClientCA ||
+		old.ClientAuth != cfg.ClientAuth ||
+		old.WebHost != cfg.WebHost ||
+		old.WebRoot != cfg.WebRoot ||
+		webEnabled(old) != webEnabled(cfg) ||
+		old.ReverseProxyHost != cfg.ReverseProxyHost ||
+		old.ReverseProxyAddr != cfg.ReverseProxyAddr ||
+		reverseProxyEnabled(old) != reverseProxyEnabled(cfg)
+}
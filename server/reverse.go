@@ -0,0 +1,223 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/accesslog"
+	config "github.com/Sherlock-Holo/camouflage/config/server"
+	"github.com/Sherlock-Holo/camouflage/metrics"
+	"github.com/Sherlock-Holo/camouflage/proto"
+	"github.com/Sherlock-Holo/camouflage/session"
+	"github.com/Sherlock-Holo/camouflage/utils"
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+// reverseAcceptTimeout is how long an inbound connection waits for the client accept stream.
+const reverseAcceptTimeout = 10 * time.Second
+
+// reverseConfig is the reverse tunnel config, it is replaced by reload, the registered tunnels
+// are kept.
+type reverseConfig struct {
+	bind  string
+	ports utils.PortRanges
+}
+
+func newReverseConfig(cfg config.Reverse) (*reverseConfig, error) {
+	ports, err := utils.ParsePortRanges(cfg.Ports)
+	if err != nil {
+		return nil, errors.Errorf("parse reverse ports failed: %w", err)
+	}
+
+	return &reverseConfig{
+		bind:  cfg.Bind,
+		ports: ports,
+	}, nil
+}
+
+// pendingConn is an inbound connection waiting for the accept stream of user.
+type pendingConn struct {
+	conn net.Conn
+	user string
+	port uint16
+}
+
+// handleReverseListen listen the port asked by client, send a token on conn for every inbound
+// connection, until conn or the listener is closed.
+func (s *Server) handleReverseListen(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	user := session.User(conn)
+
+	rawPort := make([]byte, 2)
+	if _, err := io.ReadFull(conn, rawPort); err != nil {
+		err = errors.Errorf("read reverse port failed: %w", err)
+		log.Errorf("%+v", err)
+
+		return
+	}
+
+	port := binary.BigEndian.Uint16(rawPort)
+
+	reverse := s.reverse.Load().(*reverseConfig)
+
+	if !reverse.ports.Contains(port) {
+		log.Warnf("user %s reverse port %d is not allowed", user, port)
+
+		_, _ = conn.Write([]byte{proto.ReverseFailed})
+
+		return
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(reverse.bind, strconv.Itoa(int(port))))
+	if err != nil {
+		err = errors.Errorf("user %s reverse listen port %d failed: %w", user, port, err)
+		log.Errorf("%+v", err)
+
+		_, _ = conn.Write([]byte{proto.ReverseFailed})
+
+		return
+	}
+
+	s.reverseListeners.Store(listener, struct{}{})
+
+	defer func() {
+		s.reverseListeners.Delete(listener)
+		_ = listener.Close()
+	}()
+
+	// closed by server shutdown between Store and here
+	if s.closed.Load() {
+		return
+	}
+
+	if _, err := conn.Write([]byte{proto.ReverseOK}); err != nil {
+		err = errors.Errorf("write reverse listen reply failed: %w", err)
+		log.Errorf("%+v", err)
+
+		return
+	}
+
+	log.Infof("user %s reverse listen on %s", user, listener.Addr())
+
+	// client close the stream to unregister
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		_ = listener.Close()
+	}()
+
+	for {
+		inbound, err := listener.Accept()
+		if err != nil {
+			log.Infof("user %s reverse listener %s is closed", user, listener.Addr())
+
+			return
+		}
+
+		token := make([]byte, proto.ReverseTokenSize)
+		if _, err := rand.Read(token); err != nil {
+			err = errors.Errorf("generate reverse token failed: %w", err)
+			log.Errorf("%+v", err)
+
+			_ = inbound.Close()
+
+			continue
+		}
+
+		s.pendingConns.Store(string(token), &pendingConn{
+			conn: inbound,
+			user: user,
+			port: port,
+		})
+
+		time.AfterFunc(reverseAcceptTimeout, func() {
+			if _, ok := s.pendingConns.LoadAndDelete(string(token)); ok {
+				log.Warnf("reverse connection from %s accept timeout", inbound.RemoteAddr())
+
+				_ = inbound.Close()
+			}
+		})
+
+		if _, err := conn.Write(token); err != nil {
+			err = errors.Errorf("write reverse token failed: %w", err)
+			log.Errorf("%+v", err)
+
+			return
+		}
+	}
+}
+
+// handleReverseAccept relay the inbound connection of the token on conn.
+func (s *Server) handleReverseAccept(conn net.Conn) {
+	token := make([]byte, proto.ReverseTokenSize)
+	if _, err := io.ReadFull(conn, token); err != nil {
+		err = errors.Errorf("read reverse token failed: %w", err)
+		log.Errorf("%+v", err)
+		_ = conn.Close()
+
+		return
+	}
+
+	value, ok := s.pendingConns.LoadAndDelete(string(token))
+	if !ok {
+		log.Warnf("user %s reverse token is unknown or expired", session.User(conn))
+		_ = conn.Close()
+
+		return
+	}
+
+	pending := value.(*pendingConn)
+
+	// the token is only valid for the user registered the listener
+	if user := session.User(conn); user != pending.user {
+		log.Warnf("user %s use the reverse token of user %s", user, pending.user)
+		_ = conn.Close()
+		_ = pending.conn.Close()
+
+		return
+	}
+
+	log.Debugf("user %s reverse connection from %s on port %d", pending.user, pending.conn.RemoteAddr(), pending.port)
+
+	stream := s.accessLog.Start(s.targetIdGen.Inc(), pending.conn.RemoteAddr().String(), streamSource(conn), pending.user)
+
+	// inbound connection is the source, client is the target
+	sessionConn := stream.Conn(conn)
+	inbound := pending.conn
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		_, err := io.Copy(metrics.CountWriter(sessionConn, metrics.DirectionOut), inbound)
+		stream.SetReason(accesslog.Reason("client", err))
+
+		_ = inbound.Close()
+		_ = sessionConn.Close()
+	}()
+
+	_, err := io.Copy(metrics.CountWriter(inbound, metrics.DirectionIn), sessionConn)
+	stream.SetReason(accesslog.Reason("target", err))
+
+	_ = inbound.Close()
+	_ = sessionConn.Close()
+
+	<-done
+}
+
+// closeReverseListeners stop accepting inbound connections of reverse tunnels.
+func (s *Server) closeReverseListeners() {
+	s.reverseListeners.Range(func(key, _ interface{}) bool {
+		_ = key.(net.Listener).Close()
+
+		return true
+	})
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"io"
@@ -10,22 +11,173 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/Sherlock-Holo/camouflage/accesslog"
+	"github.com/Sherlock-Holo/camouflage/certstore"
 	config "github.com/Sherlock-Holo/camouflage/config/server"
+	"github.com/Sherlock-Holo/camouflage/metrics"
+	"github.com/Sherlock-Holo/camouflage/proto"
 	"github.com/Sherlock-Holo/camouflage/session"
+	quic "github.com/Sherlock-Holo/camouflage/session/quic/server"
 	wsslink "github.com/Sherlock-Holo/camouflage/session/wsslink/server"
 	"github.com/Sherlock-Holo/libsocks"
 	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
 	errors "golang.org/x/xerrors"
 )
 
+const drainCheckInterval = 100 * time.Millisecond
+
 type Server struct {
+	// cfg, session and sessions are guarded by reloadMutex
+	cfg     config.Config
 	session session.Server
+	// sessions contain the current session and the old ones replaced by reload
+	sessions    []session.Server
+	reloadMutex sync.Mutex
+
+	streams atomic.Int64
+	closed  atomic.Bool
+	// closeChan is closed when shutdown finished closing all sessions
+	closeChan chan struct{}
+
+	certs atomic.Value // *certstore.Store
+
+	udpTimeout atomic.Duration
+	acl        atomic.Value // *acl
+
+	admin       *http.Server
+	targetIdGen atomic.Uint64
+	targets     sync.Map // *target
+
+	accessLog *accesslog.Logger
+
+	outbound atomic.Value // *outbound
+
+	reverse          atomic.Value // *reverseConfig
+	reverseListeners sync.Map     // net.Listener
+	pendingConns     sync.Map     // token -> *pendingConn
 }
 
 func New(cfg *config.Config) (*Server, error) {
-	var sess session.Server
+	certs, err := newCertStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	accessLog, err := accesslog.New("server", cfg.AccessLog)
+	if err != nil {
+		return nil, err
+	}
+
+	server := &Server{
+		cfg:       *cfg,
+		accessLog: accessLog,
+		closeChan: make(chan struct{}),
+	}
+
+	server.certs.Store(certs)
+
+	sess, err := newSession(cfg, server.getCertificate)
+	if err != nil {
+		return nil, err
+	}
+
+	egressACL, err := newACL(cfg.ACL)
+	if err != nil {
+		return nil, errors.Errorf("new acl failed: %w", err)
+	}
 
+	reverse, err := newReverseConfig(cfg.Reverse)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := newOutbound(cfg.Outbound)
+	if err != nil {
+		return nil, errors.Errorf("new outbound failed: %w", err)
+	}
+
+	server.session = sess
+	server.sessions = append(server.sessions, sess)
+	server.acl.Store(egressACL)
+	server.reverse.Store(reverse)
+	server.setUDPTimeout(cfg.UDPTimeout.Duration)
+	server.outbound.Store(out)
+
+	if cfg.Metrics != "" {
+		go func() {
+			if err := metrics.Serve(cfg.Metrics); err != nil {
+				err = errors.Errorf("enable metrics failed: %w", err)
+				log.Warnf("%+v", err)
+			}
+		}()
+	}
+
+	if cfg.Pprof != "" {
+		go func() {
+			if err := http.ListenAndServe(cfg.Pprof, nil); err != nil {
+				err := errors.Errorf("enable pprof failed: %w", err)
+				log.Warnf("%+v", err)
+			}
+		}()
+	}
+
+	if cfg.Admin.ListenAddr != "" {
+		if err := server.serveAdmin(cfg.Admin.ListenAddr, cfg.Admin.Token); err != nil {
+			return nil, err
+		}
+	}
+
+	return server, nil
+}
+
+func webEnabled(cfg *config.Config) bool {
+	return cfg.WebCrt != "" && cfg.WebKey != "" && cfg.WebHost != "" && cfg.WebRoot != ""
+}
+
+func reverseProxyEnabled(cfg *config.Config) bool {
+	return cfg.ReverseProxyCrt != "" && cfg.ReverseProxyKey != "" && cfg.ReverseProxyHost != "" && cfg.ReverseProxyAddr != ""
+}
+
+// newCertStore load the server, web and reverse proxy certificates and watch their files, the
+// server certificate is the default one.
+func newCertStore(cfg *config.Config) (*certstore.Store, error) {
+	store := certstore.New(
+		certstore.WithCheckInterval(cfg.CertCheck.Duration),
+		certstore.WithExpiryWarning(cfg.CertExpiryWarn.Duration),
+	)
+
+	pairs := []certstore.KeyPair{{CrtFile: cfg.Crt, KeyFile: cfg.Key}}
+
+	if cfg.Type == config.TypeWebsocket {
+		if webEnabled(cfg) {
+			pairs = append(pairs, certstore.KeyPair{CrtFile: cfg.WebCrt, KeyFile: cfg.WebKey})
+		}
+
+		if reverseProxyEnabled(cfg) {
+			pairs = append(pairs, certstore.KeyPair{CrtFile: cfg.ReverseProxyCrt, KeyFile: cfg.ReverseProxyKey})
+		}
+	}
+
+	if err := store.Load(pairs...); err != nil {
+		return nil, errors.Errorf("load certificates failed: %w", err)
+	}
+
+	store.Watch()
+
+	return store, nil
+}
+
+// getCertificate select certificate by the current certificate store, which is replaced by reload.
+func (s *Server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.certs.Load().(*certstore.Store).GetCertificate(hello)
+}
+
+// newSession create the session server, users can be updated later by reload.
+func newSession(cfg *config.Config, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) (session.Server, error) {
 	switch cfg.Type {
 	case config.TypeWebsocket:
 		var opts []wsslink.Option
@@ -34,87 +186,134 @@ func New(cfg *config.Config) (*Server, error) {
 			opts = append(opts, wsslink.WithHandshakeTimeout(cfg.Timeout.Duration))
 		}
 
-		if cfg.WebCrt != "" && cfg.WebKey != "" && cfg.WebHost != "" && cfg.WebRoot != "" {
-			if _, err := os.Stat(cfg.WebKey); err != nil {
-				return nil, errors.Errorf("get web root stat failed: %w", err)
+		for _, user := range cfg.Users {
+			opts = append(opts, wsslink.WithUser(user.Name, user.Secret, user.Period))
+		}
+
+		if cfg.Fallback {
+			opts = append(opts, wsslink.WithFallback())
+		}
+
+		if cfg.ClientCA != "" {
+			ca, err := os.ReadFile(cfg.ClientCA)
+			if err != nil {
+				return nil, errors.Errorf("read client ca failed: %w", err)
 			}
 
-			webCrt, err := tls.LoadX509KeyPair(cfg.WebCrt, cfg.WebKey)
+			clientCAOpt, err := wsslink.WithClientCA(ca, cfg.ClientAuth == config.ClientAuthRequired)
 			if err != nil {
-				return nil, errors.Errorf("load web certificate failed: %w", err)
+				return nil, errors.Errorf("load client ca failed: %w", err)
+			}
+
+			opts = append(opts, clientCAOpt)
+
+			log.Infof("enable client certificate auth, %s", cfg.ClientAuth)
+		}
+
+		if webEnabled(cfg) {
+			if _, err := os.Stat(cfg.WebRoot); err != nil {
+				return nil, errors.Errorf("get web root stat failed: %w", err)
 			}
 
-			opts = append(opts, wsslink.WithWeb(cfg.WebRoot, cfg.WebHost, webCrt))
+			opts = append(opts, wsslink.WithWeb(cfg.WebRoot, cfg.WebHost))
 
 			log.Info("enable web")
 		}
 
-		if cfg.ReverseProxyCrt != "" && cfg.ReverseProxyKey != "" && cfg.ReverseProxyHost != "" && cfg.ReverseProxyAddr != "" {
-			if !strings.HasPrefix(cfg.ReverseProxyAddr, "http") {
-				cfg.ReverseProxyAddr = "http://" + cfg.ReverseProxyAddr
+		if reverseProxyEnabled(cfg) {
+			reverseProxyAddr := cfg.ReverseProxyAddr
+			if !strings.HasPrefix(reverseProxyAddr, "http") {
+				reverseProxyAddr = "http://" + reverseProxyAddr
 			}
 
-			host, err := url.Parse(cfg.ReverseProxyAddr)
+			host, err := url.Parse(reverseProxyAddr)
 			if err != nil {
 				return nil, errors.Errorf("parse reverser proxy host failed: %w", err)
 			}
 
 			log.Debugf("reverse proxy host: %s", host)
 
-			reverseProxyCrt, err := tls.LoadX509KeyPair(cfg.ReverseProxyCrt, cfg.ReverseProxyKey)
-			if err != nil {
-				return nil, errors.Errorf("load reverse proxy certificate failed: %w", err)
-			}
-
-			opts = append(opts, wsslink.WithReverseProxy(host, cfg.ReverseProxyAddr, reverseProxyCrt))
+			opts = append(opts, wsslink.WithReverseProxy(host, reverseProxyAddr))
 
 			log.Info("enable reverse proxy")
 		}
 
-		// load server certificate
-		serverCert, err := tls.LoadX509KeyPair(cfg.Crt, cfg.Key)
-		if err != nil {
-			return nil, errors.Errorf("read server key pair failed: %w", err)
-		}
-
-		sess, err = wsslink.NewServer(cfg.ListenAddr, cfg.Host, cfg.Path, cfg.Secret, cfg.Period, serverCert, opts...)
+		sess, err := wsslink.NewServer(cfg.ListenAddr, cfg.Host, cfg.Path, cfg.Secret, cfg.Period, getCertificate, opts...)
 		if err != nil {
 			return nil, errors.Errorf("new wss link server failed: %w", err)
 		}
 
-		/*case config.TypeQuic:
+		return sess, nil
+
+	case config.TypeQuic:
 		var opts []quic.Option
 
-		// load server certificate
-		serverCert, err := tls.LoadX509KeyPair(cfg.Crt, cfg.Key)
-		if err != nil {
-			return nil, errors.Errorf("read server key pair failed: %w", err)
+		if cfg.Timeout.Duration > 0 {
+			opts = append(opts, quic.WithHandshakeTimeout(cfg.Timeout.Duration))
 		}
 
-		sess, err = quic.NewServer(cfg.ListenAddr, cfg.Secret, cfg.Period, serverCert, opts...)
+		for _, user := range cfg.Users {
+			opts = append(opts, quic.WithUser(user.Name, user.Secret, user.Period))
+		}
+
+		sess, err := quic.NewServer(cfg.ListenAddr, cfg.Secret, cfg.Period, getCertificate, opts...)
 		if err != nil {
 			return nil, errors.Errorf("new quic server failed: %w", err)
-		}*/
-	}
+		}
 
-	server := &Server{
-		session: sess,
-	}
+		return sess, nil
 
-	if cfg.Pprof != "" {
-		go func() {
-			if err := http.ListenAndServe(cfg.Pprof, nil); err != nil {
-				err := errors.Errorf("enable pprof failed: %w", err)
-				log.Warnf("%+v", err)
-			}
-		}()
+	default:
+		return nil, errors.Errorf("unknown type %s", cfg.Type)
 	}
 
-	return server, nil
 }
 
-func handle(conn net.Conn) {
-	address, err := libsocks.UnmarshalAddressFrom(conn)
+func (s *Server) handle(conn net.Conn) {
+	head := make([]byte, 1)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		err = errors.Errorf("server read stream header failed: %w", err)
+		log.Errorf("%+v", err)
+		_ = conn.Close()
+
+		return
+	}
+
+	switch {
+	case head[0] == proto.CmdUDPAssociate:
+		s.handleUDP(conn)
+
+		return
+
+	case head[0] == proto.CmdReverseListen:
+		s.handleReverseListen(conn)
+
+		return
+
+	case head[0] == proto.CmdReverseAccept:
+		s.handleReverseAccept(conn)
+
+		return
+
+	case head[0] == proto.CmdDNS:
+		s.handleDNS(conn)
+
+		return
+
+	case head[0] == proto.CmdPing:
+		_, _ = conn.Write([]byte{proto.PingReply})
+		_ = conn.Close()
+
+		return
+
+	case !proto.IsAddressType(head[0]):
+		log.Errorf("server unknown stream command %d", head[0])
+		_ = conn.Close()
+
+		return
+	}
+
+	address, err := libsocks.UnmarshalAddressFrom(io.MultiReader(bytes.NewReader(head), conn))
 	if err != nil {
 		err = errors.Errorf("server unmarshal address failed: %w", err)
 		log.Errorf("%+v", err)
@@ -123,44 +322,158 @@ func handle(conn net.Conn) {
 		return
 	}
 
-	remote, err := net.Dial("tcp", address.String())
+	user := session.User(conn)
+	id := s.targetIdGen.Inc()
+	stream := s.accessLog.Start(id, streamSource(conn), address.String(), user)
+
+	remote, err := s.dial(context.Background(), address)
 	if err != nil {
-		err = errors.Errorf("server connect target failed: %w", err)
+		stream.SetReason("dial failed: " + err.Error())
+		stream.End()
+
+		if errors.Is(err, errDenied) {
+			log.Warnf("user %s connect denied: %v", user, err)
+			_ = conn.Close()
+
+			return
+		}
+
+		metrics.DialFailures.WithLabelValues(metrics.DialErrorClass(err)).Inc()
+
+		err = errors.Errorf("user %s server connect target failed: %w", user, err)
 		log.Errorf("%+v", err)
 		_ = conn.Close()
 
 		return
 	}
 
-	log.Debug("start proxy")
+	log.Debugf("user %s start proxy to %s", user, address)
 
-	go func() {
-		_, _ = io.Copy(remote, conn)
-		_ = conn.Close()
-		_ = remote.Close()
-	}()
+	remote = stream.Conn(remote)
+
+	s.addTarget(id, conn, address, stream)
+	defer s.targets.Delete(id)
+
+	done := make(chan struct{})
 
 	go func() {
-		_, _ = io.Copy(conn, remote)
+		defer close(done)
+
+		_, err := io.Copy(metrics.CountWriter(remote, metrics.DirectionIn), conn)
+		stream.SetReason(accesslog.Reason("client", err))
+
 		_ = conn.Close()
 		_ = remote.Close()
 	}()
+
+	_, err = io.Copy(metrics.CountWriter(conn, metrics.DirectionOut), remote)
+	stream.SetReason(accesslog.Reason("target", err))
+
+	_ = conn.Close()
+	_ = remote.Close()
+
+	<-done
 }
 
+// Run serve the current session, sessions created by reload are served in their own goroutines,
+// it returns after shutdown closed all sessions.
 func (s *Server) Run() {
+	s.reloadMutex.Lock()
+	sess := s.session
+	s.reloadMutex.Unlock()
+
+	go s.serve(sess)
+
+	<-s.closeChan
+}
+
+// serve accept streams of sess until server is closed, after sess is replaced by reload, it
+// still accepts the streams of existing links until sess is retired.
+func (s *Server) serve(sess session.Server) {
 	for {
-		conn, err := s.session.AcceptConn(context.Background())
+		conn, err := sess.AcceptConn(context.Background())
 		if err != nil {
+			if s.closed.Load() || s.isRetired(sess) {
+				return
+			}
+
 			err = errors.Errorf("accept connection failed: %w", err)
 			log.Errorf("%+v", err)
 
 			continue
 		}
 
-		go handle(conn)
+		metrics.StreamsAccepted.WithLabelValues(sess.Name()).Inc()
+
+		s.streams.Inc()
+
+		go func() {
+			defer s.streams.Dec()
+
+			s.handle(conn)
+		}()
+	}
+}
+
+// Shutdown stop accepting new links, wait for the streams until they are done or ctx is done,
+// then close all links.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.reloadMutex.Lock()
+
+	if !s.closed.CAS(false, true) {
+		s.reloadMutex.Unlock()
+
+		return nil
+	}
+
+	// reload is refused after closed, sessions won't change any more
+	sessions := s.sessions
+
+	s.reloadMutex.Unlock()
+
+	for _, sess := range sessions {
+		_ = sess.CloseListener()
 	}
+
+	s.closeReverseListeners()
+
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	for s.streams.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Warnf("drain timeout, close %d streams", s.streams.Load())
+
+			return s.closeSessions(sessions)
+
+		case <-ticker.C:
+		}
+	}
+
+	return s.closeSessions(sessions)
 }
 
+// Close close all links immediately.
 func (s *Server) Close() error {
-	return s.session.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	return s.Shutdown(ctx)
+}
+
+func (s *Server) closeSessions(sessions []session.Server) error {
+	defer close(s.closeChan)
+
+	for _, sess := range sessions {
+		_ = sess.Close()
+	}
+
+	if s.admin != nil {
+		_ = s.admin.Close()
+	}
+
+	_ = s.accessLog.Close()
+
+	return s.certs.Load().(*certstore.Store).Close()
 }
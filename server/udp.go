@@ -0,0 +1,357 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/accesslog"
+	"github.com/Sherlock-Holo/camouflage/metrics"
+	"github.com/Sherlock-Holo/camouflage/proto"
+	"github.com/Sherlock-Holo/camouflage/session"
+	"github.com/Sherlock-Holo/libsocks"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	errors "golang.org/x/xerrors"
+)
+
+const (
+	defaultUDPTimeout = time.Minute
+
+	// maxUDPTargets limit the targets remembered by an association, the least recently active
+	// one is evicted
+	maxUDPTargets = 256
+
+	udpResolveTimeout = 5 * time.Second
+
+	// maxPendingDatagrams limit the datagrams queued while the target is resolving
+	maxPendingDatagrams = 16
+)
+
+// udpTarget is an address asked by client and the address it is resolved to.
+type udpTarget struct {
+	address libsocks.Address
+	// addr is nil while resolving, the datagrams are queued in pending
+	addr       *net.UDPAddr
+	pending    [][]byte
+	lastActive time.Time
+}
+
+// udpAssociation relay datagrams between a stream and an UDP socket, every association
+// has its own socket, and only accept datagrams from the addresses it has sent to.
+type udpAssociation struct {
+	server  *Server
+	conn    net.Conn
+	udpConn *net.UDPConn
+
+	// targets and replies are guarded by targetsMutex
+	targetsMutex sync.Mutex
+	// address asked by client -> target
+	targets map[string]*udpTarget
+	// resolved address -> targets resolved to it, so the reply carries the address client know,
+	// domains resolved to the same address keep their own entries
+	replies map[string]map[string]*udpTarget
+
+	lastActive *atomic.Int64
+	timeout    time.Duration
+
+	stream *accesslog.Stream
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// handleUDP relay the datagrams of conn until the association is closed.
+func (s *Server) handleUDP(conn net.Conn) {
+	udpConn, err := s.outbound.Load().(*outbound).listenUDP(context.Background())
+	if err != nil {
+		err = errors.Errorf("server listen udp failed: %w", err)
+		log.Errorf("%+v", err)
+		_ = conn.Close()
+
+		return
+	}
+
+	association := &udpAssociation{
+		server:     s,
+		conn:       conn,
+		udpConn:    udpConn,
+		lastActive: atomic.NewInt64(time.Now().UnixNano()),
+		timeout:    s.udpTimeout.Load(),
+		targets:    make(map[string]*udpTarget),
+		replies:    make(map[string]map[string]*udpTarget),
+		closed:     make(chan struct{}),
+		stream:     s.accessLog.Start(s.targetIdGen.Inc(), streamSource(conn), "udp", session.User(conn)),
+	}
+
+	log.Debugf("start udp relay on %s", udpConn.LocalAddr())
+
+	go association.relayToRemote()
+	go association.relayToSession()
+	go association.expire()
+
+	<-association.closed
+}
+
+func (a *udpAssociation) close() {
+	a.closeOnce.Do(func() {
+		close(a.closed)
+		_ = a.conn.Close()
+		_ = a.udpConn.Close()
+
+		a.stream.End()
+	})
+}
+
+func (a *udpAssociation) active() {
+	a.lastActive.Store(time.Now().UnixNano())
+}
+
+func (a *udpAssociation) relayToRemote() {
+	defer a.close()
+
+	reader := bufio.NewReader(a.conn)
+
+	for {
+		address, payload, err := proto.ReadDatagram(reader)
+		if err != nil {
+			a.stream.SetReason(accesslog.Reason("client", err))
+
+			return
+		}
+
+		a.active()
+
+		metrics.Bytes.WithLabelValues(metrics.DirectionIn).Add(float64(len(payload)))
+		a.stream.Sent.Add(uint64(len(payload)))
+
+		target := address.String()
+
+		udpAddr, ok := a.lookup(target, address, payload)
+		if !ok {
+			// resolving, payload is queued
+			continue
+		}
+
+		a.write(target, udpAddr, payload)
+	}
+}
+
+func (a *udpAssociation) write(target string, udpAddr *net.UDPAddr, payload []byte) {
+	if _, err := a.udpConn.WriteToUDP(payload, udpAddr); err != nil {
+		err = errors.Errorf("write udp to %s failed: %w", target, err)
+		log.Debugf("%v", err)
+	}
+}
+
+// lookup return the resolved address of target, if target is unknown, resolve it in background
+// and queue payload until it is resolved, so a slow DNS lookup won't block other targets.
+func (a *udpAssociation) lookup(target string, address libsocks.Address, payload []byte) (*net.UDPAddr, bool) {
+	a.targetsMutex.Lock()
+	defer a.targetsMutex.Unlock()
+
+	if t, ok := a.targets[target]; ok {
+		t.lastActive = time.Now()
+
+		if t.addr != nil {
+			return t.addr, true
+		}
+
+		if len(t.pending) < maxPendingDatagrams {
+			t.pending = append(t.pending, payload)
+		}
+
+		return nil, false
+	}
+
+	if len(a.targets) >= maxUDPTargets {
+		a.evictOldest()
+	}
+
+	t := &udpTarget{
+		address:    address,
+		pending:    [][]byte{payload},
+		lastActive: time.Now(),
+	}
+
+	a.targets[target] = t
+
+	go a.resolve(target, t)
+
+	return nil, false
+}
+
+// resolve resolve the target, then send the queued datagrams.
+func (a *udpAssociation) resolve(target string, t *udpTarget) {
+	ctx, cancel := context.WithTimeout(context.Background(), udpResolveTimeout)
+	defer cancel()
+
+	ips, err := a.server.resolve(ctx, t.address)
+
+	a.targetsMutex.Lock()
+
+	// evicted or expired while resolving
+	if a.targets[target] != t {
+		a.targetsMutex.Unlock()
+
+		return
+	}
+
+	if err != nil {
+		delete(a.targets, target)
+		a.targetsMutex.Unlock()
+
+		if errors.Is(err, errDenied) {
+			log.Warnf("user %s udp denied: %v", session.User(a.conn), err)
+		} else {
+			err = errors.Errorf("resolve udp target %s failed: %w", target, err)
+			log.Warnf("%v", err)
+		}
+
+		return
+	}
+
+	t.addr = &net.UDPAddr{IP: ips[0], Port: int(t.address.Port)}
+
+	key := t.addr.String()
+	if a.replies[key] == nil {
+		a.replies[key] = make(map[string]*udpTarget)
+	}
+
+	a.replies[key][target] = t
+
+	pending := t.pending
+	t.pending = nil
+
+	a.targetsMutex.Unlock()
+
+	for _, payload := range pending {
+		a.write(target, t.addr, payload)
+	}
+}
+
+// replyAddress return the address client know for the datagram from addr, when several
+// targets are resolved to addr, the most recently active one is used.
+func (a *udpAssociation) replyAddress(addr *net.UDPAddr) (libsocks.Address, bool) {
+	a.targetsMutex.Lock()
+	defer a.targetsMutex.Unlock()
+
+	var latest *udpTarget
+
+	for _, t := range a.replies[addr.String()] {
+		if latest == nil || t.lastActive.After(latest.lastActive) {
+			latest = t
+		}
+	}
+
+	if latest == nil {
+		return libsocks.Address{}, false
+	}
+
+	latest.lastActive = time.Now()
+
+	return latest.address, true
+}
+
+// removeTarget must be called with targetsMutex held.
+func (a *udpAssociation) removeTarget(target string, t *udpTarget) {
+	delete(a.targets, target)
+
+	if t.addr == nil {
+		return
+	}
+
+	key := t.addr.String()
+
+	delete(a.replies[key], target)
+
+	if len(a.replies[key]) == 0 {
+		delete(a.replies, key)
+	}
+}
+
+// evictOldest must be called with targetsMutex held.
+func (a *udpAssociation) evictOldest() {
+	var (
+		oldestTarget string
+		oldest       *udpTarget
+	)
+
+	for target, t := range a.targets {
+		if oldest == nil || t.lastActive.Before(oldest.lastActive) {
+			oldestTarget = target
+			oldest = t
+		}
+	}
+
+	if oldest != nil {
+		a.removeTarget(oldestTarget, oldest)
+	}
+}
+
+// expireTargets forget the targets which are idle for a.timeout.
+func (a *udpAssociation) expireTargets() {
+	a.targetsMutex.Lock()
+	defer a.targetsMutex.Unlock()
+
+	for target, t := range a.targets {
+		if time.Since(t.lastActive) > a.timeout {
+			a.removeTarget(target, t)
+		}
+	}
+}
+
+func (a *udpAssociation) relayToSession() {
+	defer a.close()
+
+	buf := make([]byte, proto.MaxDatagramSize)
+
+	for {
+		n, addr, err := a.udpConn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		address, ok := a.replyAddress(addr)
+		if !ok {
+			log.Debugf("drop udp datagram from unknown address %s", addr)
+			continue
+		}
+
+		a.active()
+
+		metrics.Bytes.WithLabelValues(metrics.DirectionOut).Add(float64(n))
+		a.stream.Received.Add(uint64(n))
+
+		if err := proto.WriteDatagram(a.conn, address, buf[:n]); err != nil {
+			a.stream.SetReason(accesslog.Reason("client", err))
+
+			return
+		}
+	}
+}
+
+// expire close the association when it is idle for a.timeout, and forget the idle targets.
+func (a *udpAssociation) expire() {
+	ticker := time.NewTicker(a.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.closed:
+			return
+
+		case <-ticker.C:
+			if time.Since(time.Unix(0, a.lastActive.Load())) > a.timeout {
+				log.Debugf("udp relay on %s idle timeout", a.udpConn.LocalAddr())
+				a.stream.SetReason("idle timeout")
+				a.close()
+				return
+			}
+
+			a.expireTargets()
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package session
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	errors "golang.org/x/xerrors"
+)
+
+const (
+	defaultBackoffBase      = 500 * time.Millisecond
+	defaultBackoffMax       = time.Minute
+	defaultBreakerThreshold = 5
+)
+
+// ErrCircuitOpen is returned when the circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	// BreakerClosed connect normally.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fail fast until the backoff delay elapsed.
+	BreakerOpen
+	// BreakerHalfOpen allow one connect to test if server recovers.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+
+	case BreakerHalfOpen:
+		return "half-open"
+
+	default:
+		return "closed"
+	}
+}
+
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Status is the reconnect status of a client, Name is the server address.
+type Status struct {
+	Name        string       `json:"name"`
+	State       BreakerState `json:"state"`
+	Failures    int          `json:"failures"`     // consecutive connect failures
+	LastError   string       `json:"last_error"`   // last connect error
+	RetryAt     time.Time    `json:"retry_at"`     // when breaker is open, connect is allowed after it
+	ActiveLinks int          `json:"active_links"` // healthy links
+}
+
+// StatusReporter is implemented by the clients which report their reconnect status, the
+// upstream group report the status of all members.
+type StatusReporter interface {
+	Status() []Status
+}
+
+// Breaker is a circuit breaker with jittered exponential backoff, it opens after threshold
+// consecutive connect failures.
+type Breaker struct {
+	mutex sync.Mutex
+
+	name     string
+	state    BreakerState
+	failures int
+	lastErr  error
+	retryAt  time.Time
+
+	threshold int
+	base      time.Duration
+	max       time.Duration
+}
+
+// NewBreaker create a Breaker of the client name, zero threshold, base or max is the default.
+func NewBreaker(name string, threshold int, base, max time.Duration) *Breaker {
+	b := &Breaker{
+		name:      name,
+		threshold: defaultBreakerThreshold,
+		base:      defaultBackoffBase,
+		max:       defaultBackoffMax,
+	}
+
+	if threshold > 0 {
+		b.threshold = threshold
+	}
+
+	if base > 0 {
+		b.base = base
+	}
+
+	if max > 0 {
+		b.max = max
+	}
+
+	return b
+}
+
+// Allow report if a connect can start, when open and the backoff delay elapsed, only one
+// connect is allowed as a half-open trial.
+func (b *Breaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Now().Before(b.retryAt) {
+			return errors.Errorf("%w, last error: %v", ErrCircuitOpen, b.lastErr)
+		}
+
+		b.setState(BreakerHalfOpen)
+
+		return nil
+
+	case BreakerHalfOpen:
+		return errors.Errorf("%w, last error: %v", ErrCircuitOpen, b.lastErr)
+
+	default:
+		return nil
+	}
+}
+
+// Success reset the failures and close the breaker.
+func (b *Breaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures = 0
+	b.lastErr = nil
+	b.setState(BreakerClosed)
+}
+
+// Failure record a connect failure, the breaker opens after threshold failures.
+func (b *Breaker) Failure(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.failures++
+	b.lastErr = err
+
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.retryAt = time.Now().Add(b.backoff())
+		b.setState(BreakerOpen)
+	}
+}
+
+// RetryDelay return how long to wait before next connect.
+func (b *Breaker) RetryDelay() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.state == BreakerOpen {
+		if delay := time.Until(b.retryAt); delay > 0 {
+			return delay
+		}
+
+		return 0
+	}
+
+	return b.backoff()
+}
+
+// backoff is base * 2^(failures-1) capped by max, with jitter in [delay/2, delay).
+func (b *Breaker) backoff() time.Duration {
+	delay := b.max
+
+	if b.failures < 32 {
+		if d := b.base << uint(b.failures); d > 0 && d/2 < b.max {
+			delay = d / 2
+		}
+	}
+
+	half := delay / 2
+
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	if b.state == state {
+		return
+	}
+
+	b.state = state
+
+	switch state {
+	case BreakerOpen:
+		log.Warnf("%s circuit breaker open after %d failures, retry at %s: %v",
+			b.name, b.failures, b.retryAt.Format(time.RFC3339), b.lastErr)
+
+	case BreakerHalfOpen:
+		log.Infof("%s circuit breaker half-open, try to connect", b.name)
+
+	case BreakerClosed:
+		log.Infof("%s circuit breaker closed", b.name)
+	}
+}
+
+// Status return the breaker status, ActiveLinks is filled by the client.
+func (b *Breaker) Status() Status {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	status := Status{
+		Name:     b.name,
+		State:    b.state,
+		Failures: b.failures,
+	}
+
+	if b.lastErr != nil {
+		status.LastError = b.lastErr.Error()
+	}
+
+	if b.state == BreakerOpen {
+		status.RetryAt = b.retryAt
+	}
+
+	return status
+}
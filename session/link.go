@@ -0,0 +1,131 @@
+package session
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+)
+
+// linkIdGen is shared by all servers, so link IDs are unique after the server is rebound by
+// reload.
+var linkIdGen atomic.Uint64
+
+// LinkInfo is the status of a client link, bytes in are received from client, bytes out are
+// sent to client.
+type LinkInfo struct {
+	ID        uint64    `json:"id"`
+	Remote    string    `json:"remote"`
+	User      string    `json:"user"`
+	Connected time.Time `json:"connected"`
+	Streams   int64     `json:"streams"`
+	BytesIn   uint64    `json:"bytes_in"`
+	BytesOut  uint64    `json:"bytes_out"`
+}
+
+// Link count the streams and bytes of a client link.
+type Link struct {
+	id        uint64
+	remote    string
+	user      string
+	connected time.Time
+
+	streams  atomic.Int64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+
+	close func() error
+}
+
+// NewLink create a Link with a new ID, closeFunc close the underlying link.
+func NewLink(remote net.Addr, user string, closeFunc func() error) *Link {
+	return &Link{
+		id:        linkIdGen.Inc(),
+		remote:    remote.String(),
+		user:      user,
+		connected: time.Now(),
+		close:     closeFunc,
+	}
+}
+
+func (l *Link) ID() uint64 {
+	return l.id
+}
+
+func (l *Link) Remote() string {
+	return l.remote
+}
+
+func (l *Link) User() string {
+	return l.user
+}
+
+func (l *Link) Info() LinkInfo {
+	return LinkInfo{
+		ID:        l.id,
+		Remote:    l.remote,
+		User:      l.user,
+		Connected: l.connected,
+		Streams:   l.streams.Load(),
+		BytesIn:   l.bytesIn.Load(),
+		BytesOut:  l.bytesOut.Load(),
+	}
+}
+
+func (l *Link) Close() error {
+	return l.close()
+}
+
+// Track count conn as a stream of the link until it is closed, the user of the link is
+// attached to conn.
+func (l *Link) Track(conn net.Conn) net.Conn {
+	l.streams.Inc()
+
+	return WithUser(&linkConn{
+		Conn: conn,
+		link: l,
+	}, l.user)
+}
+
+type linkConn struct {
+	net.Conn
+
+	link      *Link
+	closeOnce sync.Once
+}
+
+func (c *linkConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.link.bytesIn.Add(uint64(n))
+
+	return n, err
+}
+
+func (c *linkConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.link.bytesOut.Add(uint64(n))
+
+	return n, err
+}
+
+func (c *linkConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.link.streams.Dec()
+	})
+
+	return c.Conn.Close()
+}
+
+// LinkOf return the link which conn belongs to.
+func LinkOf(conn net.Conn) (*Link, bool) {
+	if uc, ok := conn.(*userConn); ok {
+		conn = uc.Conn
+	}
+
+	if lc, ok := conn.(*linkConn); ok {
+		return lc.link, true
+	}
+
+	return nil, false
+}
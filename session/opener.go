@@ -0,0 +1,33 @@
+package session
+
+import (
+	"net"
+)
+
+type openerConn struct {
+	net.Conn
+
+	opener Client
+}
+
+// WithOpener attach the client which opened conn, other streams to the same server can be
+// opened by it.
+func WithOpener(conn net.Conn, opener Client) net.Conn {
+	return &openerConn{
+		Conn:   conn,
+		opener: opener,
+	}
+}
+
+// Opener return the client which opened conn, conn may be returned by WithUser.
+func Opener(conn net.Conn) (Client, bool) {
+	if uc, ok := conn.(*userConn); ok {
+		conn = uc.Conn
+	}
+
+	if oc, ok := conn.(*openerConn); ok {
+		return oc.opener, true
+	}
+
+	return nil, false
+}
@@ -0,0 +1,403 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/metrics"
+	"github.com/Sherlock-Holo/camouflage/session"
+	"github.com/Sherlock-Holo/camouflage/utils"
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/singleflight"
+	errors "golang.org/x/xerrors"
+)
+
+const (
+	alpn = "camouflage"
+
+	authOK = 0
+
+	// maxFieldSize is the max size of an auth field, which has 1 byte length prefix.
+	maxFieldSize = 255
+)
+
+type Option interface {
+	apply(link *quicLink)
+}
+
+type debugCA []byte
+
+func (d debugCA) apply(link *quicLink) {
+	if link.tlsConfig.RootCAs == nil {
+		link.tlsConfig.RootCAs = x509.NewCertPool()
+	}
+
+	link.tlsConfig.RootCAs.AppendCertsFromPEM(d)
+}
+
+func WithDebugCA(ca []byte) Option {
+	return debugCA(ca)
+}
+
+type handshakeTimeout time.Duration
+
+func (h handshakeTimeout) apply(link *quicLink) {
+	link.quicConfig.HandshakeIdleTimeout = time.Duration(h)
+	link.handshakeTimeout = time.Duration(h)
+}
+
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return handshakeTimeout(timeout)
+}
+
+type user string
+
+func (u user) apply(link *quicLink) {
+	link.user = string(u)
+}
+
+// WithUser set the user name sent to server, server use it to choose the TOTP secret.
+func WithUser(name string) Option {
+	return user(name)
+}
+
+type backoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+func (b backoff) apply(link *quicLink) {
+	link.backoffBase = b.base
+	link.backoffMax = b.max
+}
+
+// WithBackoff set the reconnect backoff, the delay starts from base and doubles until max.
+func WithBackoff(base, max time.Duration) Option {
+	return backoff{
+		base: base,
+		max:  max,
+	}
+}
+
+type breakerThreshold int
+
+func (b breakerThreshold) apply(link *quicLink) {
+	link.breakerThreshold = int(b)
+}
+
+// WithBreakerThreshold set how many consecutive connect failures open the circuit breaker.
+func WithBreakerThreshold(threshold int) Option {
+	return breakerThreshold(threshold)
+}
+
+type quicLink struct {
+	addr       string
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+
+	user   string
+	secret string
+	period uint
+
+	handshakeTimeout time.Duration
+
+	backoffBase      time.Duration
+	backoffMax       time.Duration
+	breakerThreshold int
+	breaker          *session.Breaker
+
+	// conn is read without lock, connectGroup shares one in-flight connect among waiters
+	conn         atomic.Value
+	connectGroup singleflight.Group
+	closed       atomic.Bool
+	closeChan    chan struct{}
+}
+
+func NewClient(addr, totpSecret string, totpPeriod uint, opts ...Option) *quicLink {
+	ql := &quicLink{
+		addr: addr,
+
+		tlsConfig: &tls.Config{
+			NextProtos: []string{alpn},
+			MinVersion: tls.VersionTLS13,
+		},
+
+		quicConfig: &quic.Config{
+			KeepAlivePeriod: 5 * time.Second,
+		},
+
+		secret: totpSecret,
+		period: totpPeriod,
+
+		closeChan: make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt.apply(ql)
+	}
+
+	ql.breaker = session.NewBreaker(addr, ql.breakerThreshold, ql.backoffBase, ql.backoffMax)
+
+	return ql
+}
+
+func (q *quicLink) Name() string {
+	return "quic"
+}
+
+func (q *quicLink) Close() error {
+	if q.closed.CAS(false, true) {
+		close(q.closeChan)
+
+		if conn, ok := q.conn.Load().(*quic.Conn); ok {
+			return conn.CloseWithError(0, "")
+		}
+	}
+
+	return nil
+}
+
+func (q *quicLink) OpenConn(ctx context.Context) (net.Conn, error) {
+	if q.closed.Load() {
+		return nil, &net.OpError{
+			Op:  "open",
+			Net: q.Name(),
+			Err: errors.New("session is closed"),
+		}
+	}
+
+	conn, err := q.getConn(ctx)
+	if err != nil {
+		return nil, errors.Errorf("connect quic link failed: %w", err)
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, errors.Errorf("open quic stream failed: %w", err)
+	}
+
+	sc := &streamConn{
+		Stream:     stream,
+		localAddr:  conn.LocalAddr(),
+		remoteAddr: conn.RemoteAddr(),
+	}
+
+	if raw := ctx.Value(session.PreData{}); raw != nil {
+		preData, ok := raw.([]byte)
+		if !ok {
+			_ = sc.Close()
+
+			return nil, &net.OpError{
+				Op:  "open",
+				Net: q.Name(),
+				Err: errors.New("invalid pre-data"),
+			}
+		}
+
+		log.Debug("dial data")
+
+		if _, err := sc.Write(preData); err != nil {
+			_ = sc.Close()
+
+			return nil, errors.Errorf("write pre-data failed: %w", err)
+		}
+	}
+
+	return session.WithUser(session.WithOpener(sc, q), q.tokenUser()), nil
+}
+
+// getConn return the current quic connection, lazy connect or reconnect when it is closed. The
+// connect is shared by all waiters and runs with ctx of the waiter which starts it, if that
+// waiter cancels it, the other waiters start a new one.
+func (q *quicLink) getConn(ctx context.Context) (*quic.Conn, error) {
+	for {
+		if conn := q.aliveConn(); conn != nil {
+			return conn, nil
+		}
+
+		resultChan := q.connectGroup.DoChan("connect", func() (interface{}, error) {
+			return q.reconnect(ctx)
+		})
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case result := <-resultChan:
+			// canceled by the waiter which started it
+			if errors.Is(result.Err, context.Canceled) && ctx.Err() == nil && !q.closed.Load() {
+				continue
+			}
+
+			if result.Err != nil {
+				return nil, result.Err
+			}
+
+			return result.Val.(*quic.Conn), nil
+		}
+	}
+}
+
+// reconnect connect and store the quic connection, it is canceled by ctx or link close.
+func (q *quicLink) reconnect(ctx context.Context) (*quic.Conn, error) {
+	if conn := q.aliveConn(); conn != nil {
+		return conn, nil
+	}
+
+	if err := q.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go func() {
+		select {
+		case <-q.closeChan:
+			cancel()
+
+		case <-ctx.Done():
+		}
+	}()
+
+	if q.handshakeTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, q.handshakeTimeout)
+		defer cancel()
+	}
+
+	conn, err := q.connect(ctx)
+	if err != nil {
+		// canceled by waiter or link close, not a failure of server
+		if !errors.Is(err, context.Canceled) {
+			q.breaker.Failure(err)
+		}
+
+		return nil, err
+	}
+
+	q.breaker.Success()
+
+	if q.closed.Load() {
+		_ = conn.CloseWithError(0, "")
+
+		return nil, errors.New("session is closed")
+	}
+
+	q.conn.Store(conn)
+
+	log.Debug("quic link connect success")
+
+	return conn, nil
+}
+
+// Status return the reconnect status.
+func (q *quicLink) Status() []session.Status {
+	status := q.breaker.Status()
+
+	if q.aliveConn() != nil {
+		status.ActiveLinks = 1
+	}
+
+	return []session.Status{status}
+}
+
+// aliveConn return the current quic connection if it is not closed.
+func (q *quicLink) aliveConn() *quic.Conn {
+	conn, ok := q.conn.Load().(*quic.Conn)
+	if !ok {
+		return nil
+	}
+
+	select {
+	case <-conn.Context().Done():
+		return nil
+
+	default:
+		return conn
+	}
+}
+
+// connect dial the server and finish TOTP auth on the first stream.
+func (q *quicLink) connect(ctx context.Context) (*quic.Conn, error) {
+	start := time.Now()
+
+	conn, err := quic.DialAddr(ctx, q.addr, q.tlsConfig, q.quicConfig)
+	if err != nil {
+		return nil, errors.Errorf("dial quic failed: %w", err)
+	}
+
+	if err := q.auth(ctx, conn); err != nil {
+		_ = conn.CloseWithError(0, "")
+
+		return nil, err
+	}
+
+	metrics.HandshakeDuration.WithLabelValues(q.Name()).Observe(time.Since(start).Seconds())
+	metrics.ActiveLinks.WithLabelValues(q.Name()).Inc()
+
+	go func() {
+		<-conn.Context().Done()
+		metrics.ActiveLinks.WithLabelValues(q.Name()).Dec()
+	}()
+
+	return conn, nil
+}
+
+// tokenUser return the user name which the link is authenticated as.
+func (q *quicLink) tokenUser() string {
+	if q.user == "" {
+		return utils.DefaultUser
+	}
+
+	return q.user
+}
+
+func (q *quicLink) auth(ctx context.Context, conn *quic.Conn) error {
+	if len(q.user) > maxFieldSize {
+		return errors.Errorf("user %s is longer than %d bytes", q.user, maxFieldSize)
+	}
+
+	token, err := utils.GenToken(q.tokenUser(), q.secret)
+	if err != nil {
+		return errors.Errorf("generate token failed: %w", err)
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return errors.Errorf("open auth stream failed: %w", err)
+	}
+
+	defer stream.CancelRead(0)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = stream.SetDeadline(deadline)
+	}
+
+	// [user length 1 byte | user | credential length 1 byte | credential]
+	request := append([]byte{byte(len(q.user))}, q.user...)
+	request = append(request, byte(len(token)))
+	request = append(request, token...)
+
+	if _, err := stream.Write(request); err != nil {
+		return errors.Errorf("write auth request failed: %w", err)
+	}
+
+	result := make([]byte, 1)
+	if _, err := io.ReadFull(stream, result); err != nil {
+		return errors.Errorf("read auth response failed: %w", err)
+	}
+
+	_ = stream.Close()
+
+	if result[0] != authOK {
+		return errors.New("connect failed: maybe TOTP secret is wrong")
+	}
+
+	return nil
+}
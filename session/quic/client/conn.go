@@ -0,0 +1,30 @@
+package client
+
+import (
+	"net"
+
+	"github.com/quic-go/quic-go"
+)
+
+// streamConn make a quic stream act as a net.Conn.
+type streamConn struct {
+	*quic.Stream
+
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (s *streamConn) LocalAddr() net.Addr {
+	return s.localAddr
+}
+
+func (s *streamConn) RemoteAddr() net.Addr {
+	return s.remoteAddr
+}
+
+// Close close both direction, quic stream Close only close the write direction.
+func (s *streamConn) Close() error {
+	s.Stream.CancelRead(0)
+
+	return s.Stream.Close()
+}
@@ -0,0 +1,369 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/metrics"
+	"github.com/Sherlock-Holo/camouflage/session"
+	"github.com/Sherlock-Holo/camouflage/utils"
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	errors "golang.org/x/xerrors"
+)
+
+const (
+	alpn = "camouflage"
+
+	authOK     = 0
+	authFailed = 1
+
+	// errorCodeAuthFailed is the application error code when client auth failed.
+	errorCodeAuthFailed quic.ApplicationErrorCode = 1
+
+	defaultAuthTimeout = 30 * time.Second
+)
+
+type Option interface {
+	apply(link *quicLink)
+}
+
+type handshakeTimeout time.Duration
+
+func (h handshakeTimeout) apply(link *quicLink) {
+	link.quicConfig.HandshakeIdleTimeout = time.Duration(h)
+	link.authTimeout = time.Duration(h)
+}
+
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return handshakeTimeout(timeout)
+}
+
+type user utils.User
+
+func (u user) apply(link *quicLink) {
+	link.userList = append(link.userList, utils.User(u))
+}
+
+// WithUser add a TOTP user, client send the user name in the auth stream.
+func WithUser(name, secret string, period uint) Option {
+	return user{
+		Name:   name,
+		Secret: secret,
+		Period: period,
+	}
+}
+
+type quicLink struct {
+	listenAddr string
+	tlsConfig  *tls.Config
+	quicConfig *quic.Config
+	listener   *quic.Listener
+
+	userList []utils.User
+	users    atomic.Value // *utils.Users
+
+	authTimeout time.Duration
+
+	linkMap sync.Map // *session.Link
+
+	acceptChan chan net.Conn
+
+	startOnce      sync.Once
+	closed         atomic.Bool
+	closeChan      chan struct{}
+	listenerClosed atomic.Bool
+}
+
+func NewServer(listenAddr, secret string, period uint, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), opts ...Option) (*quicLink, error) {
+	ql := &quicLink{
+		listenAddr: listenAddr,
+
+		tlsConfig: &tls.Config{
+			NextProtos:     []string{alpn},
+			MinVersion:     tls.VersionTLS13,
+			GetCertificate: getCertificate,
+		},
+
+		quicConfig: &quic.Config{
+			KeepAlivePeriod: 5 * time.Second,
+		},
+
+		authTimeout: defaultAuthTimeout,
+
+		acceptChan: make(chan net.Conn, 100),
+		closeChan:  make(chan struct{}),
+	}
+
+	if secret != "" {
+		ql.userList = append(ql.userList, utils.User{
+			Name:   utils.DefaultUser,
+			Secret: secret,
+			Period: period,
+		})
+	}
+
+	for _, opt := range opts {
+		opt.apply(ql)
+	}
+
+	ql.users.Store(utils.NewUsers(ql.userList...))
+
+	listener, err := quic.ListenAddr(listenAddr, ql.tlsConfig, ql.quicConfig)
+	if err != nil {
+		return nil, errors.Errorf("listen %s failed: %w", listenAddr, err)
+	}
+
+	ql.listener = listener
+
+	return ql, nil
+}
+
+func (q *quicLink) SetUsers(users ...utils.User) {
+	q.users.Store(q.users.Load().(*utils.Users).Update(users...))
+}
+
+func (q *quicLink) CloseListener() error {
+	if q.listenerClosed.CAS(false, true) {
+		return q.listener.Close()
+	}
+
+	return nil
+}
+
+func (q *quicLink) Links() []session.LinkInfo {
+	var links []session.LinkInfo
+
+	q.linkMap.Range(func(_, value interface{}) bool {
+		links = append(links, value.(*session.Link).Info())
+
+		return true
+	})
+
+	return links
+}
+
+func (q *quicLink) CloseLink(id uint64) bool {
+	value, ok := q.linkMap.Load(id)
+	if !ok {
+		return false
+	}
+
+	_ = value.(*session.Link).Close()
+
+	return true
+}
+
+func (q *quicLink) Name() string {
+	return "quic"
+}
+
+func (q *quicLink) Close() error {
+	if q.closed.CAS(false, true) {
+		close(q.closeChan)
+
+		_ = q.CloseListener()
+
+		q.linkMap.Range(func(_, value interface{}) bool {
+			_ = value.(*session.Link).Close()
+
+			return true
+		})
+	}
+
+	return nil
+}
+
+func (q *quicLink) AcceptConn(ctx context.Context) (net.Conn, error) {
+	// lazy start
+	q.startOnce.Do(func() {
+		go q.acceptLoop()
+	})
+
+	if q.closed.Load() {
+		return nil, &net.OpError{
+			Op:  "open",
+			Net: q.Name(),
+			Err: errors.New("quic link is closed"),
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+
+	case <-q.closeChan:
+		return nil, &net.OpError{
+			Op:  "open",
+			Net: q.Name(),
+			Err: errors.New("quic link is closed"),
+		}
+
+	case conn := <-q.acceptChan:
+		return conn, nil
+	}
+}
+
+func (q *quicLink) acceptLoop() {
+	for {
+		conn, err := q.listener.Accept(context.Background())
+		if err != nil {
+			if q.listenerClosed.Load() {
+				return
+			}
+
+			err = errors.Errorf("accept quic connection failed: %w", err)
+			log.Errorf("%+v", err)
+
+			continue
+		}
+
+		go q.handleConn(conn)
+	}
+}
+
+func (q *quicLink) handleConn(conn *quic.Conn) {
+	userName, err := q.auth(conn)
+	if err != nil {
+		err = errors.Errorf("quic link auth failed: %w", err)
+		log.Warnf("%+v", err)
+
+		metrics.AuthFailures.WithLabelValues(q.Name()).Inc()
+
+		_ = conn.CloseWithError(errorCodeAuthFailed, "")
+
+		return
+	}
+
+	log.Debugf("user %s from %s connected", userName, conn.RemoteAddr())
+
+	clientLink := session.NewLink(conn.RemoteAddr(), userName, func() error {
+		return conn.CloseWithError(0, "")
+	})
+
+	q.linkMap.Store(clientLink.ID(), clientLink)
+	metrics.ActiveLinks.WithLabelValues(q.Name()).Inc()
+
+	defer func() {
+		_ = conn.CloseWithError(0, "")
+
+		q.linkMap.Delete(clientLink.ID())
+		metrics.ActiveLinks.WithLabelValues(q.Name()).Dec()
+	}()
+
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+		if err != nil {
+			err = errors.Errorf("accept quic stream failed: %w", err)
+
+			if isConnClosed(err) {
+				log.Debugf("%v", err)
+			} else {
+				log.Errorf("%+v", err)
+			}
+
+			return
+		}
+
+		sc := &streamConn{
+			Stream:     stream,
+			localAddr:  conn.LocalAddr(),
+			remoteAddr: conn.RemoteAddr(),
+		}
+
+		trackedConn := clientLink.Track(sc)
+
+		select {
+		default:
+			log.Warn("accept queue is full")
+			metrics.AcceptQueueDrops.WithLabelValues(q.Name()).Inc()
+
+			_ = trackedConn.Close()
+
+		case q.acceptChan <- trackedConn:
+		}
+	}
+}
+
+// isConnClosed report if err is a normal close of the connection, closed by application or idle
+// timeout.
+func isConnClosed(err error) bool {
+	var (
+		appErr     *quic.ApplicationError
+		timeoutErr *quic.IdleTimeoutError
+	)
+
+	return errors.As(err, &appErr) || errors.As(err, &timeoutErr)
+}
+
+// auth read user name and credential from the first stream of the connection and verify it,
+// credential is a token or TOTP code.
+func (q *quicLink) auth(conn *quic.Conn) (userName string, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), q.authTimeout)
+	defer cancel()
+
+	stream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		return "", errors.Errorf("accept auth stream failed: %w", err)
+	}
+
+	defer func() {
+		stream.CancelRead(0)
+		_ = stream.Close()
+	}()
+
+	_ = stream.SetDeadline(time.Now().Add(q.authTimeout))
+
+	// [user length 1 byte | user | credential length 1 byte | credential]
+	rawUser, err := readField(stream)
+	if err != nil {
+		return "", errors.Errorf("read user failed: %w", err)
+	}
+
+	credential, err := readField(stream)
+	if err != nil {
+		return "", errors.Errorf("read credential failed: %w", err)
+	}
+
+	userName = string(rawUser)
+	if userName == "" {
+		userName = utils.DefaultUser
+	}
+
+	ok, err := q.users.Load().(*utils.Users).Verify(userName, string(credential))
+	if err != nil {
+		return "", errors.Errorf("verify code error: %w", err)
+	}
+
+	if !ok {
+		_, _ = stream.Write([]byte{authFailed})
+
+		return "", errors.Errorf("user %s invalid or replayed credential", userName)
+	}
+
+	if _, err := stream.Write([]byte{authOK}); err != nil {
+		return "", errors.Errorf("write auth response failed: %w", err)
+	}
+
+	return userName, nil
+}
+
+// readField read a field which has 1 byte length prefix.
+func readField(r io.Reader) ([]byte, error) {
+	length := make([]byte, 1)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, err
+	}
+
+	field := make([]byte, length[0])
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+
+	return field, nil
+}
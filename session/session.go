@@ -4,6 +4,8 @@ import (
 	"context"
 	"io"
 	"net"
+
+	"github.com/Sherlock-Holo/camouflage/utils"
 )
 
 type Client interface {
@@ -20,4 +22,16 @@ type Server interface {
 	Name() string
 
 	AcceptConn(ctx context.Context) (net.Conn, error)
+
+	// CloseListener stop accepting new links, existing links keep working until they are closed.
+	CloseListener() error
+
+	// SetUsers replace the TOTP users, the replay filters of kept users are reused.
+	SetUsers(users ...utils.User)
+
+	// Links return the status of the connected client links.
+	Links() []LinkInfo
+
+	// CloseLink close the client link of id, if not found, return false.
+	CloseLink(id uint64) bool
 }
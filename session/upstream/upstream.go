@@ -0,0 +1,340 @@
+// Package upstream balance streams among several session clients, unhealthy clients are
+// found by probing and skipped until they recover.
+package upstream
+
+import (
+	"context"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Sherlock-Holo/camouflage/proto"
+	"github.com/Sherlock-Holo/camouflage/session"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	errors "golang.org/x/xerrors"
+)
+
+type Policy int
+
+const (
+	// Failover use the first healthy member.
+	Failover Policy = iota
+	// RoundRobin use healthy members by weight in turn.
+	RoundRobin
+	// LowestLatency use the healthy member which has the lowest probe latency.
+	LowestLatency
+)
+
+const (
+	defaultCheckInterval = 10 * time.Second
+	maxCheckTimeout      = 5 * time.Second
+)
+
+type Member struct {
+	Name   string
+	Client session.Client
+	Weight uint
+}
+
+type member struct {
+	Member
+
+	healthy *atomic.Bool
+	latency *atomic.Duration
+
+	// currentWeight is the smooth weighted round-robin state, guarded by group.rrMutex
+	currentWeight int
+}
+
+type Option interface {
+	apply(g *group)
+}
+
+type checkInterval time.Duration
+
+func (c checkInterval) apply(g *group) {
+	if c > 0 {
+		g.checkInterval = time.Duration(c)
+	}
+}
+
+// WithCheckInterval set the health probe interval.
+func WithCheckInterval(interval time.Duration) Option {
+	return checkInterval(interval)
+}
+
+type group struct {
+	members []*member
+	policy  Policy
+
+	checkInterval time.Duration
+
+	rrMutex sync.Mutex
+
+	startOnce sync.Once
+	closed    atomic.Bool
+	closeChan chan struct{}
+}
+
+func NewClient(policy Policy, members []Member, opts ...Option) *group {
+	g := &group{
+		policy:        policy,
+		checkInterval: defaultCheckInterval,
+		closeChan:     make(chan struct{}),
+	}
+
+	for _, m := range members {
+		if m.Weight == 0 {
+			m.Weight = 1
+		}
+
+		g.members = append(g.members, &member{
+			Member:  m,
+			healthy: atomic.NewBool(true),
+			latency: atomic.NewDuration(0),
+		})
+	}
+
+	for _, opt := range opts {
+		opt.apply(g)
+	}
+
+	return g
+}
+
+func (g *group) Name() string {
+	return "upstream"
+}
+
+func (g *group) Close() error {
+	if g.closed.CAS(false, true) {
+		close(g.closeChan)
+
+		for _, m := range g.members {
+			_ = m.Client.Close()
+		}
+	}
+
+	return nil
+}
+
+func (g *group) OpenConn(ctx context.Context) (net.Conn, error) {
+	if g.closed.Load() {
+		return nil, &net.OpError{
+			Op:  "open",
+			Net: g.Name(),
+			Err: errors.New("session is closed"),
+		}
+	}
+
+	// lazy start
+	g.startOnce.Do(func() {
+		go g.check()
+	})
+
+	var lastErr error
+
+	candidates := g.candidates()
+
+	for i, m := range candidates {
+		conn, err := openMember(ctx, m, len(candidates)-i)
+		if err == nil {
+			return conn, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, err
+		}
+
+		lastErr = err
+
+		if m.healthy.CAS(true, false) {
+			log.Warnf("upstream %s is down, switch to next: %v", m.Name, err)
+		}
+	}
+
+	return nil, errors.Errorf("all upstream failed: %w", lastErr)
+}
+
+// Status return the reconnect status of the members which report it.
+func (g *group) Status() []session.Status {
+	var statuses []session.Status
+
+	for _, m := range g.members {
+		if reporter, ok := m.Client.(session.StatusReporter); ok {
+			statuses = append(statuses, reporter.Status()...)
+		}
+	}
+
+	return statuses
+}
+
+// openMember open a conn on member m, if ctx has a deadline, the rest time is shared by the
+// remaining members, so a hanging member won't use up the time of the next ones.
+func openMember(ctx context.Context, m *member, remaining int) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok && remaining > 1 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, time.Until(deadline)/time.Duration(remaining))
+		defer cancel()
+	}
+
+	return m.Client.OpenConn(ctx)
+}
+
+// candidates return healthy members ordered by policy, then the unhealthy ones as last resort.
+func (g *group) candidates() []*member {
+	var healthy, unhealthy []*member
+
+	for _, m := range g.members {
+		if m.healthy.Load() {
+			healthy = append(healthy, m)
+		} else {
+			unhealthy = append(unhealthy, m)
+		}
+	}
+
+	switch g.policy {
+	case RoundRobin:
+		if next := g.nextRoundRobin(healthy); next != nil {
+			ordered := []*member{next}
+
+			for _, m := range healthy {
+				if m != next {
+					ordered = append(ordered, m)
+				}
+			}
+
+			healthy = ordered
+		}
+
+	case LowestLatency:
+		// unknown latency is sorted last
+		sort.SliceStable(healthy, func(i, j int) bool {
+			li, lj := healthy[i].latency.Load(), healthy[j].latency.Load()
+
+			return li != 0 && (lj == 0 || li < lj)
+		})
+	}
+
+	return append(healthy, unhealthy...)
+}
+
+// nextRoundRobin is the smooth weighted round-robin.
+func (g *group) nextRoundRobin(members []*member) *member {
+	g.rrMutex.Lock()
+	defer g.rrMutex.Unlock()
+
+	var (
+		best  *member
+		total int
+	)
+
+	for _, m := range members {
+		m.currentWeight += int(m.Weight)
+		total += int(m.Weight)
+
+		if best == nil || m.currentWeight > best.currentWeight {
+			best = m
+		}
+	}
+
+	if best != nil {
+		best.currentWeight -= total
+	}
+
+	return best
+}
+
+// check probe all members every checkInterval.
+func (g *group) check() {
+	ticker := time.NewTicker(g.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		var wg sync.WaitGroup
+
+		for _, m := range g.members {
+			wg.Add(1)
+
+			go func(m *member) {
+				defer wg.Done()
+
+				g.probe(m)
+			}(m)
+		}
+
+		wg.Wait()
+
+		select {
+		case <-g.closeChan:
+			return
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe open a ping stream on the member and wait for the reply.
+func (g *group) probe(m *member) {
+	timeout := g.checkInterval
+	if timeout > maxCheckTimeout {
+		timeout = maxCheckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ctx = context.WithValue(ctx, session.PreData{}, []byte{proto.CmdPing})
+
+	start := time.Now()
+
+	err := func() error {
+		conn, err := m.Client.OpenConn(ctx)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		deadline, _ := ctx.Deadline()
+		_ = conn.SetReadDeadline(deadline)
+
+		reply := make([]byte, 1)
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return errors.Errorf("read ping reply failed: %w", err)
+		}
+
+		if reply[0] != proto.PingReply {
+			return errors.Errorf("unknown ping reply %d", reply[0])
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		if g.closed.Load() {
+			return
+		}
+
+		if m.healthy.CAS(true, false) {
+			log.Warnf("upstream %s health check failed: %v", m.Name, err)
+		}
+
+		return
+	}
+
+	latency := time.Since(start)
+	m.latency.Store(latency)
+
+	if m.healthy.CAS(false, true) {
+		log.Infof("upstream %s is up", m.Name)
+	}
+
+	log.Debugf("upstream %s latency %s", m.Name, latency)
+}
@@ -0,0 +1,28 @@
+package session
+
+import (
+	"net"
+)
+
+type userConn struct {
+	net.Conn
+
+	user string
+}
+
+// WithUser attach the authenticated user name to conn.
+func WithUser(conn net.Conn, user string) net.Conn {
+	return &userConn{
+		Conn: conn,
+		user: user,
+	}
+}
+
+// User return the authenticated user name of conn, if unknown, return empty string.
+func User(conn net.Conn) string {
+	if uc, ok := conn.(*userConn); ok {
+		return uc.user
+	}
+
+	return ""
+}
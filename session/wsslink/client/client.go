@@ -9,6 +9,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/Sherlock-Holo/camouflage/metrics"
 	"github.com/Sherlock-Holo/camouflage/session"
 	"github.com/Sherlock-Holo/camouflage/utils"
 	wsWrapper "github.com/Sherlock-Holo/goutils/websocket"
@@ -47,16 +48,90 @@ func WithHandshakeTimeout(timeout time.Duration) Option {
 	return handshakeTimeout(timeout)
 }
 
+type clientCert tls.Certificate
+
+func (c clientCert) apply(link *wssLink) {
+	link.wsDialer.TLSClientConfig.Certificates = append(link.wsDialer.TLSClientConfig.Certificates, tls.Certificate(c))
+}
+
+// WithClientCert present a client certificate for mutual TLS.
+func WithClientCert(cert tls.Certificate) Option {
+	return clientCert(cert)
+}
+
+type user string
+
+func (u user) apply(link *wssLink) {
+	link.user = string(u)
+}
+
+// WithUser set the user name sent to server, server use it to choose the TOTP secret.
+func WithUser(name string) Option {
+	return user(name)
+}
+
+type poolSize int
+
+func (p poolSize) apply(link *wssLink) {
+	if p > 0 {
+		link.poolSize = int(p)
+	}
+}
+
+// WithPoolSize set the number of parallel websocket links, new streams are opened on the
+// least-loaded one.
+func WithPoolSize(size int) Option {
+	return poolSize(size)
+}
+
+type backoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+func (b backoff) apply(link *wssLink) {
+	link.backoffBase = b.base
+	link.backoffMax = b.max
+}
+
+// WithBackoff set the reconnect backoff, the delay starts from base and doubles until max.
+func WithBackoff(base, max time.Duration) Option {
+	return backoff{
+		base: base,
+		max:  max,
+	}
+}
+
+type breakerThreshold int
+
+func (b breakerThreshold) apply(link *wssLink) {
+	link.breakerThreshold = int(b)
+}
+
+// WithBreakerThreshold set how many consecutive connect failures open the circuit breaker.
+func WithBreakerThreshold(threshold int) Option {
+	return breakerThreshold(threshold)
+}
+
 type wssLink struct {
 	wsURL    string
 	wsDialer websocket.Dialer
 
+	user   string
 	secret string
 	period uint
 
-	manager      link.Manager
-	connectMutex sync.Mutex
-	closed       atomic.Bool
+	poolSize int
+	pool     []*pooledLink
+
+	backoffBase      time.Duration
+	backoffMax       time.Duration
+	breakerThreshold int
+	breaker          *session.Breaker
+
+	startOnce sync.Once
+	closed    atomic.Bool
+	closeChan chan struct{}
 }
 
 func (w *wssLink) Name() string {
@@ -72,18 +147,31 @@ func NewClient(wsURL, totpSecret string, totpPeriod uint, opts ...Option) *wssLi
 
 		secret: totpSecret,
 		period: totpPeriod,
+
+		poolSize:  1,
+		closeChan: make(chan struct{}),
 	}
 
 	for _, opt := range opts {
 		opt.apply(wl)
 	}
 
+	wl.breaker = session.NewBreaker(wsURL, wl.breakerThreshold, wl.backoffBase, wl.backoffMax)
+
+	for i := 0; i < wl.poolSize; i++ {
+		wl.pool = append(wl.pool, newPooledLink(i))
+	}
+
 	return wl
 }
 
 func (w *wssLink) Close() error {
 	if w.closed.CAS(false, true) {
-		return w.manager.Close()
+		close(w.closeChan)
+
+		for _, pl := range w.pool {
+			_ = pl.close()
+		}
 	}
 
 	return nil
@@ -98,47 +186,30 @@ func (w *wssLink) OpenConn(ctx context.Context) (net.Conn, error) {
 		}
 	}
 
-	w.connectMutex.Lock()
-
-	if w.manager == nil {
-		for {
-			err := w.reconnect(ctx)
-			switch {
-			case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
-				netErr := &net.OpError{
-					Op:  "open",
-					Net: w.Name(),
-					Err: err,
-				}
-
-				// when connect timeout, manager may can't recover
-				_ = w.manager.Close()
-				w.manager = nil
-
-				w.connectMutex.Unlock()
-
-				return nil, errors.Errorf("connect wss link failed: %w", netErr)
-
-			default:
-				continue
-
-			case err == nil:
-			}
-
-			log.Debug("wss link connect success")
-			break
+	// lazy start, until OpenConn called, won't dial websocket
+	w.startOnce.Do(func() {
+		for _, pl := range w.pool {
+			go w.maintain(pl)
 		}
-	}
+	})
 
-	if w.manager.IsClosed() {
-		if err := w.reconnect(ctx); err != nil {
-			w.connectMutex.Unlock()
+	pl, manager := w.pick()
+	if manager == nil {
+		// no healthy link, connect the first one and wait for it
+		pl = w.pool[0]
 
-			return nil, errors.Errorf("reconnect wss link failed: %w", err)
+		state, err := pl.ensure(ctx, w)
+		if err != nil {
+			return nil, errors.Errorf("connect wss link failed: %w", err)
 		}
+
+		manager = state.manager
 	}
 
-	w.connectMutex.Unlock()
+	var (
+		stream link.Link
+		err    error
+	)
 
 	if raw := ctx.Value(session.PreData{}); raw != nil {
 		preData, ok := raw.([]byte)
@@ -151,26 +222,160 @@ func (w *wssLink) OpenConn(ctx context.Context) (net.Conn, error) {
 		}
 
 		log.Debug("dial data")
-		return w.manager.DialData(ctx, preData)
+		stream, err = manager.DialData(ctx, preData)
+	} else {
+		stream, err = manager.Dial(ctx)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return session.WithUser(session.WithOpener(pl.track(stream), w), w.tokenUser()), nil
+}
+
+// pick return the healthy link which has the least streams.
+func (w *wssLink) pick() (*pooledLink, link.Manager) {
+	var (
+		picked  *pooledLink
+		manager link.Manager
+	)
+
+	for _, pl := range w.pool {
+		m := pl.getManager()
+		if m == nil {
+			continue
+		}
+
+		if picked == nil || pl.streams.Load() < picked.streams.Load() {
+			picked = pl
+			manager = m
+		}
+	}
+
+	return picked, manager
+}
+
+// maintain keep the link connected in background, a dead link is replaced, failed connect is
+// retried with backoff.
+func (w *wssLink) maintain(pl *pooledLink) {
+	for !w.closed.Load() {
+		state, err := pl.ensure(context.Background(), w)
+		if err != nil {
+			delay := w.breaker.RetryDelay()
+
+			if !errors.Is(err, session.ErrCircuitOpen) {
+				err = errors.Errorf("connect wss link %d failed, retry after %s: %w", pl.index, delay, err)
+				log.Warnf("%v", err)
+			}
+
+			select {
+			case <-w.closeChan:
+				return
+
+			case <-time.After(delay):
+			}
+
+			continue
+		}
+
+		<-state.done
+
+		log.Debugf("wss link %d is closed", pl.index)
+	}
+}
+
+// Status return the reconnect status.
+func (w *wssLink) Status() []session.Status {
+	status := w.breaker.Status()
+
+	for _, pl := range w.pool {
+		if pl.getManager() != nil {
+			status.ActiveLinks++
+		}
+	}
+
+	return []session.Status{status}
+}
+
+// connectContext return a context of ctx which is also canceled when the link is closed.
+func (w *wssLink) connectContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-w.closeChan:
+			cancel()
+
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// watchManager decrease ActiveLinks when manager is closed, server never open streams to
+// client, so Accept only returns when the manager is closed.
+func (w *wssLink) watchManager(manager link.Manager) {
+	defer metrics.ActiveLinks.WithLabelValues(w.Name()).Dec()
+
+	for {
+		conn, err := manager.Accept()
+		if err != nil {
+			return
+		}
+
+		_ = conn.Close()
+	}
+}
+
+// tokenUser return the user name which the link is authenticated as.
+func (w *wssLink) tokenUser() string {
+	if w.user == "" {
+		return utils.DefaultUser
 	}
 
-	return w.manager.Dial(ctx)
+	return w.user
 }
 
-// lazy init, until OpenConn called, won't dial websocket
-func (w *wssLink) reconnect(ctx context.Context) error {
-	if w.manager != nil {
-		_ = w.manager.Close()
+// authHeader return the TOTP auth headers, if no secret, only client certificate is used.
+func (w *wssLink) authHeader() (http.Header, error) {
+	httpHeader := http.Header{}
+
+	if w.secret == "" {
+		return httpHeader, nil
+	}
+
+	code, err := utils.GenCode(w.secret, w.period)
+	if err != nil {
+		return nil, errors.Errorf("generate TOTP code failed: %w", err)
+	}
+
+	token, err := utils.GenToken(w.tokenUser(), w.secret)
+	if err != nil {
+		return nil, errors.Errorf("generate token failed: %w", err)
 	}
 
+	// keep TOTP code for old server
+	httpHeader.Set("totp-code", code)
+	httpHeader.Set("totp-token", token)
+
+	if w.user != "" {
+		httpHeader.Set("totp-user", w.user)
+	}
+
+	return httpHeader, nil
+}
+
+// connect dial websocket and create a link.Manager on it.
+func (w *wssLink) connect(ctx context.Context) (link.Manager, error) {
 	for i := 0; i < 2; i++ {
-		code, err := utils.GenCode(w.secret, w.period)
+		httpHeader, err := w.authHeader()
 		if err != nil {
-			return errors.Errorf("generate TOTP code failed: %w", err)
+			return nil, err
 		}
 
-		httpHeader := http.Header{}
-		httpHeader.Set("totp-code", code)
+		start := time.Now()
 
 		conn, response, err := w.wsDialer.DialContext(ctx, w.wsURL, httpHeader)
 		switch {
@@ -179,7 +384,7 @@ func (w *wssLink) reconnect(ctx context.Context) error {
 
 			if response.StatusCode == http.StatusForbidden {
 				if i == 1 {
-					return errors.New("connect failed: maybe TOTP secret is wrong")
+					return nil, errors.New("connect failed: maybe TOTP secret is wrong")
 				} else {
 					continue
 				}
@@ -188,7 +393,7 @@ func (w *wssLink) reconnect(ctx context.Context) error {
 			fallthrough
 
 		default:
-			return errors.Errorf("connect failed: %w", err)
+			return nil, errors.Errorf("connect failed: %w", err)
 
 		case err == nil:
 		}
@@ -196,9 +401,12 @@ func (w *wssLink) reconnect(ctx context.Context) error {
 		linkCfg := link.DefaultConfig(link.ClientMode)
 		linkCfg.KeepaliveInterval = 5 * time.Second
 
-		w.manager = link.NewManager(wsWrapper.NewWrapper(conn), linkCfg)
+		manager := link.NewManager(wsWrapper.NewWrapper(conn), linkCfg)
+
+		metrics.HandshakeDuration.WithLabelValues(w.Name()).Observe(time.Since(start).Seconds())
+		metrics.ActiveLinks.WithLabelValues(w.Name()).Inc()
 
-		return nil
+		return manager, nil
 	}
 
 	panic("unreachable")
@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Sherlock-Holo/link"
+	log "github.com/sirupsen/logrus"
+	"go.uber.org/atomic"
+	"golang.org/x/sync/singleflight"
+	errors "golang.org/x/xerrors"
+)
+
+// pooledLink is a slot of the link pool, it holds a link.Manager and the number of
+// streams opened on it.
+type pooledLink struct {
+	index int
+
+	// state is read without lock, connectGroup shares one in-flight connect among waiters
+	state        atomic.Value
+	connectGroup singleflight.Group
+
+	streams *atomic.Int64
+}
+
+type linkState struct {
+	manager link.Manager
+	// done is closed when manager is closed
+	done chan struct{}
+}
+
+func newPooledLink(index int) *pooledLink {
+	return &pooledLink{
+		index:   index,
+		streams: atomic.NewInt64(0),
+	}
+}
+
+// getState return the healthy link state or nil.
+func (p *pooledLink) getState() *linkState {
+	state, ok := p.state.Load().(*linkState)
+	if !ok || state.manager.IsClosed() {
+		return nil
+	}
+
+	return state
+}
+
+// getManager return the healthy manager or nil.
+func (p *pooledLink) getManager() link.Manager {
+	if state := p.getState(); state != nil {
+		return state.manager
+	}
+
+	return nil
+}
+
+// ensure return the healthy link state, connect a new one when the old is dead. The connect is
+// shared by all waiters and runs with ctx of the waiter which starts it, if that waiter cancels
+// it, the other waiters start a new one.
+func (p *pooledLink) ensure(ctx context.Context, w *wssLink) (*linkState, error) {
+	for {
+		if state := p.getState(); state != nil {
+			return state, nil
+		}
+
+		resultChan := p.connectGroup.DoChan("connect", func() (interface{}, error) {
+			return p.connect(ctx, w)
+		})
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case result := <-resultChan:
+			// canceled by the waiter which started it
+			if errors.Is(result.Err, context.Canceled) && ctx.Err() == nil && !w.closed.Load() {
+				continue
+			}
+
+			if result.Err != nil {
+				return nil, result.Err
+			}
+
+			return result.Val.(*linkState), nil
+		}
+	}
+}
+
+// connect connect the link and store its state, it is canceled by ctx or link close.
+func (p *pooledLink) connect(ctx context.Context, w *wssLink) (*linkState, error) {
+	if state := p.getState(); state != nil {
+		return state, nil
+	}
+
+	if err := w.breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := w.connectContext(ctx)
+	defer cancel()
+
+	manager, err := w.connect(ctx)
+	if err != nil {
+		// canceled by waiter or link close, not a failure of server
+		if !errors.Is(err, context.Canceled) {
+			w.breaker.Failure(err)
+		}
+
+		return nil, err
+	}
+
+	w.breaker.Success()
+
+	if w.closed.Load() {
+		_ = manager.Close()
+
+		return nil, errors.New("session is closed")
+	}
+
+	log.Debugf("wss link %d connect success", p.index)
+
+	state := &linkState{
+		manager: manager,
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		w.watchManager(manager)
+		close(state.done)
+	}()
+
+	p.state.Store(state)
+
+	return state, nil
+}
+
+func (p *pooledLink) close() error {
+	if state, ok := p.state.Load().(*linkState); ok {
+		return state.manager.Close()
+	}
+
+	return nil
+}
+
+// track count the stream until it is closed.
+func (p *pooledLink) track(l link.Link) link.Link {
+	p.streams.Inc()
+
+	return &streamConn{
+		Link:    l,
+		streams: p.streams,
+	}
+}
+
+type streamConn struct {
+	link.Link
+
+	closeOnce sync.Once
+	streams   *atomic.Int64
+}
+
+func (s *streamConn) Close() error {
+	s.closeOnce.Do(func() {
+		s.streams.Dec()
+	})
+
+	return s.Link.Close()
+}
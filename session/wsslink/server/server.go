@@ -3,13 +3,17 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/Sherlock-Holo/camouflage/metrics"
+	"github.com/Sherlock-Holo/camouflage/session"
 	"github.com/Sherlock-Holo/camouflage/utils"
 	wsWrapper "github.com/Sherlock-Holo/goutils/websocket"
 	"github.com/Sherlock-Holo/link"
@@ -36,26 +40,30 @@ func WithHandshakeTimeout(timeout time.Duration) Option {
 type webConfig struct {
 	root string
 	host string
-	crt  tls.Certificate
 }
 
 func (w webConfig) apply(link *wssLink) {
-	link.tlsConfig.Certificates = append(link.tlsConfig.Certificates, w.crt)
-	link.httpMux.Handle(w.host+"/", enableGzip(http.FileServer(http.Dir(w.root))))
+	handler := enableGzip(http.FileServer(http.Dir(w.root)))
+
+	link.httpMux.Handle(w.host+"/", handler)
+
+	if link.decoyHandler == nil {
+		link.decoyHandler = handler
+	}
 }
 
-func WithWeb(root, host string, crt tls.Certificate) Option {
+// WithWeb serve a static web site on host, the certificate of host should be in the
+// certificate getter of server.
+func WithWeb(root, host string) Option {
 	return webConfig{
 		root: root,
 		host: host,
-		crt:  crt,
 	}
 }
 
 type reverseProxyConfig struct {
 	host       *url.URL
 	realserver string
-	crt        tls.Certificate
 }
 
 func (r reverseProxyConfig) apply(link *wssLink) {
@@ -68,16 +76,79 @@ func (r reverseProxyConfig) apply(link *wssLink) {
 	}
 
 	link.httpMux.Handle(r.host.Host+"/", proxy)
+
+	if link.decoyHandler == nil {
+		link.decoyHandler = proxy
+	}
 }
 
-func WithReverseProxy(host *url.URL, realserver string, crt tls.Certificate) Option {
+// WithReverseProxy proxy the requests of host to realserver, the certificate of host should be
+// in the certificate getter of server.
+func WithReverseProxy(host *url.URL, realserver string) Option {
 	return reverseProxyConfig{
 		host:       host,
 		realserver: realserver,
-		crt:        crt,
 	}
 }
 
+type user utils.User
+
+func (u user) apply(link *wssLink) {
+	link.userList = append(link.userList, utils.User(u))
+}
+
+// WithUser add a TOTP user, client send the user name with totp-user header.
+func WithUser(name, secret string, period uint) Option {
+	return user{
+		Name:   name,
+		Secret: secret,
+		Period: period,
+	}
+}
+
+type fallback struct{}
+
+func (fallback) apply(link *wssLink) {
+	link.fallback = true
+}
+
+// WithFallback serve the unauthenticated requests on the websocket host by the web or
+// reverse proxy, so probes can't tell the websocket path from a normal path. If neither is
+// enabled, reply not found like an unknown path.
+func WithFallback() Option {
+	return fallback{}
+}
+
+type clientCA struct {
+	pool     *x509.CertPool
+	required bool
+}
+
+func (c clientCA) apply(link *wssLink) {
+	link.clientCAs = c.pool
+
+	if c.required {
+		link.clientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		link.clientAuth = tls.VerifyClientCertIfGiven
+	}
+}
+
+// WithClientCA enable mutual TLS on the websocket host, the client certificate common name
+// is the user name, and TOTP is not needed. If not required, client without certificate
+// can still use TOTP.
+func WithClientCA(ca []byte, required bool) (Option, error) {
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no valid client ca certificate")
+	}
+
+	return clientCA{
+		pool:     pool,
+		required: required,
+	}, nil
+}
+
 type wssLink struct {
 	upgrader websocket.Upgrader
 
@@ -88,44 +159,75 @@ type wssLink struct {
 	tlsConfig   *tls.Config
 	tlsListener net.Listener
 
-	secret string
-	period uint
+	fallback     bool
+	decoyHandler http.Handler
+
+	clientCAs  *x509.CertPool
+	clientAuth tls.ClientAuthType
+
+	userList []utils.User
+	users    atomic.Value // *utils.Users
 
-	linkManagerIdGen *atomic.Uint64
-	linkManagerMap   sync.Map
+	linkMap sync.Map // *session.Link
 
 	acceptChan chan net.Conn
 
 	startOnce sync.Once
+	draining  atomic.Bool
 	closed    atomic.Bool
+	closeChan chan struct{}
 }
 
-func NewServer(listenAddr, host, wsPath, secret string, period uint, serverCert tls.Certificate, opts ...Option) (*wssLink, error) {
+// NewServer create a websocket link server, getCertificate select the certificate of the
+// websocket host, web and reverse proxy hosts.
+func NewServer(listenAddr, host, wsPath, secret string, period uint, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), opts ...Option) (*wssLink, error) {
 	wl := &wssLink{
 		host: host,
 
 		tlsConfig: &tls.Config{
-			NextProtos:   []string{"h2"},
-			MinVersion:   tls.VersionTLS12,
-			Certificates: []tls.Certificate{serverCert},
+			NextProtos:     []string{"h2"},
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: getCertificate,
 		},
 
-		secret: secret,
-		period: period,
-
-		linkManagerIdGen: atomic.NewUint64(0),
-
 		acceptChan: make(chan net.Conn, 100),
+		closeChan:  make(chan struct{}),
 	}
 
 	wl.httpMux = http.NewServeMux()
 
 	wl.httpMux.Handle(host+wsPath, http.HandlerFunc(wl.wsHandle))
 
+	if secret != "" {
+		wl.userList = append(wl.userList, utils.User{
+			Name:   utils.DefaultUser,
+			Secret: secret,
+			Period: period,
+		})
+	}
+
 	for _, opt := range opts {
 		opt.apply(wl)
 	}
 
+	wl.users.Store(utils.NewUsers(wl.userList...))
+
+	if wl.decoyHandler == nil {
+		wl.decoyHandler = http.NotFoundHandler()
+	}
+
+	// the other paths of the websocket host are served by the decoy too, unless the web is on
+	// the same host, so the websocket path looks like any other path
+	if wl.fallback {
+		if _, pattern := wl.httpMux.Handler(&http.Request{Host: host, URL: &url.URL{Path: "/"}}); pattern != host+"/" {
+			wl.httpMux.Handle(host+"/", wl.decoyHandler)
+		}
+	}
+
+	if wl.clientCAs != nil {
+		wl.enableClientAuth()
+	}
+
 	wl.httpServer = http.Server{Handler: wl.httpMux}
 
 	tlsListener, err := tls.Listen("tcp", listenAddr, wl.tlsConfig)
@@ -138,19 +240,82 @@ func NewServer(listenAddr, host, wsPath, secret string, period uint, serverCert
 	return wl, nil
 }
 
+// enableClientAuth only request client certificate when client access the websocket host,
+// so visitors of other sites won't be asked for a certificate.
+func (w *wssLink) enableClientAuth() {
+	mtlsConfig := w.tlsConfig.Clone()
+	mtlsConfig.ClientCAs = w.clientCAs
+	mtlsConfig.ClientAuth = w.clientAuth
+
+	wsHost := stripPort(w.host)
+
+	w.tlsConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if strings.EqualFold(hello.ServerName, wsHost) {
+			return mtlsConfig, nil
+		}
+
+		return nil, nil
+	}
+}
+
+// stripPort return host without port.
+func stripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return host
+}
+
+func (w *wssLink) SetUsers(users ...utils.User) {
+	w.users.Store(w.users.Load().(*utils.Users).Update(users...))
+}
+
+func (w *wssLink) CloseListener() error {
+	// kept alive http connections can still send upgrade requests
+	w.draining.Store(true)
+
+	return w.tlsListener.Close()
+}
+
+func (w *wssLink) Links() []session.LinkInfo {
+	var links []session.LinkInfo
+
+	w.linkMap.Range(func(_, value interface{}) bool {
+		links = append(links, value.(*session.Link).Info())
+
+		return true
+	})
+
+	return links
+}
+
+func (w *wssLink) CloseLink(id uint64) bool {
+	value, ok := w.linkMap.Load(id)
+	if !ok {
+		return false
+	}
+
+	_ = value.(*session.Link).Close()
+
+	return true
+}
+
 func (w *wssLink) Name() string {
 	return "wsslink"
 }
 
 func (w *wssLink) Close() error {
 	if w.closed.CAS(false, true) {
+		close(w.closeChan)
+
 		timeout, cancelFunc := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancelFunc()
 
 		_ = w.httpServer.Shutdown(timeout)
 
-		w.linkManagerMap.Range(func(_, value interface{}) bool {
-			_ = value.(link.Manager).Close()
+		w.linkMap.Range(func(_, value interface{}) bool {
+			_ = value.(*session.Link).Close()
 
 			return true
 		})
@@ -181,26 +346,96 @@ func (w *wssLink) AcceptConn(ctx context.Context) (net.Conn, error) {
 	case <-ctx.Done():
 		return nil, ctx.Err()
 
+	case <-w.closeChan:
+		return nil, &net.OpError{
+			Op:  "open",
+			Net: w.Name(),
+			Err: errors.New("wss link is closed"),
+		}
+
 	case conn := <-w.acceptChan:
 		return conn, nil
 	}
 }
 
 func (w *wssLink) wsHandle(writer http.ResponseWriter, request *http.Request) {
-	code := request.Header.Get("totp-code")
+	// check before verify, a request which can't be upgraded won't use up the credential
+	if !websocket.IsWebSocketUpgrade(request) {
+		w.reject(writer, request)
 
-	ok, err := utils.VerifyCode(code, w.secret, w.period)
-	if err != nil {
-		err = errors.Errorf("verify code error: %w", err)
-		log.Warnf("%+v", err)
+		return
+	}
+
+	// new client send a single use token, old client only send TOTP code
+	credential := request.Header.Get("totp-token")
+	if credential == "" {
+		credential = request.Header.Get("totp-code")
+	}
+
+	userName := request.Header.Get("totp-user")
+	if userName == "" {
+		userName = utils.DefaultUser
+	}
+
+	var ok bool
 
-		http.Error(writer, "server internal error", http.StatusInternalServerError)
+	switch {
+	case request.TLS != nil && len(request.TLS.VerifiedChains) > 0:
+		// client certificate is only verified on the connection of the websocket host SNI, a
+		// request of another host can't use it
+		if !strings.EqualFold(request.TLS.ServerName, stripPort(request.Host)) {
+			log.Debugf("request from %s SNI mismatch host %s", request.RemoteAddr, request.Host)
+
+			break
+		}
+
+		// client certificate is verified, TOTP is not needed
+		ok = true
+
+		if cn := request.TLS.VerifiedChains[0][0].Subject.CommonName; cn != "" {
+			userName = cn
+		}
+
+	case w.clientAuth == tls.RequireAndVerifyClientCert:
+		log.Debugf("request from %s has no client certificate", request.RemoteAddr)
+
+	default:
+		var err error
+
+		users := w.users.Load().(*utils.Users)
+
+		ok, err = users.Verify(userName, credential)
+		if ok && credential != request.Header.Get("totp-code") {
+			users.Consume(userName, request.Header.Get("totp-code"))
+		}
+
+		if err != nil {
+			err = errors.Errorf("verify code error: %w", err)
+			log.Warnf("%+v", err)
+
+			if w.fallback {
+				w.decoyHandler.ServeHTTP(writer, request)
+			} else {
+				http.Error(writer, "server internal error", http.StatusInternalServerError)
+			}
+
+			return
+		}
+	}
+
+	if !ok {
+		log.Debugf("user %s from %s auth failed", userName, request.RemoteAddr)
+
+		metrics.AuthFailures.WithLabelValues(w.Name()).Inc()
+
+		w.reject(writer, request)
 
 		return
 	}
 
-	if !ok || !websocket.IsWebSocketUpgrade(request) {
-		writer.WriteHeader(http.StatusBadRequest)
+	if w.draining.Load() {
+		http.Error(writer, "server is shutting down", http.StatusServiceUnavailable)
+
 		return
 	}
 
@@ -216,15 +451,19 @@ func (w *wssLink) wsHandle(writer http.ResponseWriter, request *http.Request) {
 
 	manager := link.NewManager(wsWrapper.NewWrapper(conn), linkCfg)
 
-	linkManagerId := w.linkManagerIdGen.Add(1) - 1
+	clientLink := session.NewLink(conn.RemoteAddr(), userName, manager.Close)
+
+	w.linkMap.Store(clientLink.ID(), clientLink)
+	metrics.ActiveLinks.WithLabelValues(w.Name()).Inc()
 
-	w.linkManagerMap.Store(linkManagerId, manager)
+	log.Debugf("user %s from %s connected", userName, request.RemoteAddr)
 
 	go func() {
 		defer func() {
 			_ = manager.Close()
 
-			w.linkManagerMap.Delete(linkManagerId)
+			w.linkMap.Delete(clientLink.ID())
+			metrics.ActiveLinks.WithLabelValues(w.Name()).Dec()
 		}()
 
 		for {
@@ -235,14 +474,29 @@ func (w *wssLink) wsHandle(writer http.ResponseWriter, request *http.Request) {
 				return
 			}
 
+			trackedConn := clientLink.Track(linkConn)
+
 			select {
 			default:
 				log.Warn("accept queue is full")
+				metrics.AcceptQueueDrops.WithLabelValues(w.Name()).Inc()
 
-				_ = linkConn.Close()
+				_ = trackedConn.Close()
 
-			case w.acceptChan <- linkConn:
+			case w.acceptChan <- trackedConn:
 			}
 		}
 	}()
 }
+
+// reject reply the request which is not an authenticated websocket upgrade, it is served by the
+// decoy when fallback is enabled.
+func (w *wssLink) reject(writer http.ResponseWriter, request *http.Request) {
+	if w.fallback {
+		w.decoyHandler.ServeHTTP(writer, request)
+
+		return
+	}
+
+	writer.WriteHeader(http.StatusBadRequest)
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+type PortRange struct {
+	From, To uint16
+}
+
+// PortRanges is a list of port ranges.
+type PortRanges []PortRange
+
+// ParsePortRanges parse port ranges like 80,443,8000-9000.
+func ParsePortRanges(s string) (PortRanges, error) {
+	var ranges PortRanges
+
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		from, to := field, field
+		if i := strings.IndexByte(field, '-'); i >= 0 {
+			from, to = field[:i], field[i+1:]
+		}
+
+		fromPort, err := strconv.ParseUint(strings.TrimSpace(from), 10, 16)
+		if err != nil {
+			return nil, xerrors.Errorf("parse port %s failed: %w", from, err)
+		}
+
+		toPort, err := strconv.ParseUint(strings.TrimSpace(to), 10, 16)
+		if err != nil {
+			return nil, xerrors.Errorf("parse port %s failed: %w", to, err)
+		}
+
+		if fromPort > toPort {
+			return nil, xerrors.Errorf("invalid port range %s", field)
+		}
+
+		ranges = append(ranges, PortRange{From: uint16(fromPort), To: uint16(toPort)})
+	}
+
+	return ranges, nil
+}
+
+// Contains report if port is in any range.
+func (p PortRanges) Contains(port uint16) bool {
+	for _, pr := range p {
+		if port >= pr.From && port <= pr.To {
+			return true
+		}
+	}
+
+	return false
+}
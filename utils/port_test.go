@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePortRanges(t *testing.T) {
+	tests := []struct {
+		s      string
+		ranges PortRanges
+		err    bool
+	}{
+		{s: "", ranges: nil},
+		{s: "80", ranges: PortRanges{{80, 80}}},
+		{s: "80,443", ranges: PortRanges{{80, 80}, {443, 443}}},
+		{s: " 80 , 8000 - 9000 ,", ranges: PortRanges{{80, 80}, {8000, 9000}}},
+		{s: "0-65535", ranges: PortRanges{{0, 65535}}},
+		{s: "443-443", ranges: PortRanges{{443, 443}}},
+		{s: "9000-8000", err: true},
+		{s: "65536", err: true},
+		{s: "-1", err: true},
+		{s: "80-", err: true},
+		{s: "http", err: true},
+		{s: "1-2-3", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			ranges, err := ParsePortRanges(tt.s)
+			if tt.err {
+				if err == nil {
+					t.Errorf("ParsePortRanges(%q) = %v, want error", tt.s, ranges)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParsePortRanges(%q) failed: %v", tt.s, err)
+			}
+
+			if !reflect.DeepEqual(ranges, tt.ranges) {
+				t.Errorf("ParsePortRanges(%q) = %v, want %v", tt.s, ranges, tt.ranges)
+			}
+		})
+	}
+}
+
+func TestPortRangesContains(t *testing.T) {
+	ranges := PortRanges{{25, 25}, {465, 587}}
+
+	tests := []struct {
+		port     uint16
+		contains bool
+	}{
+		{25, true},
+		{24, false},
+		{26, false},
+		{465, true},
+		{500, true},
+		{587, true},
+		{588, false},
+	}
+
+	for _, tt := range tests {
+		if contains := ranges.Contains(tt.port); contains != tt.contains {
+			t.Errorf("Contains(%d) = %v, want %v", tt.port, contains, tt.contains)
+		}
+	}
+
+	if PortRanges(nil).Contains(80) {
+		t.Error("empty ranges contain 80")
+	}
+}
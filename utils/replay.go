@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// replayFilter remember used credentials until they expire.
+type replayFilter struct {
+	mutex sync.Mutex
+	used  map[string]time.Time
+}
+
+func newReplayFilter() *replayFilter {
+	return &replayFilter{used: make(map[string]time.Time)}
+}
+
+// check return true and remember credential if it is never used, otherwise return false.
+func (r *replayFilter) check(credential string, ttl time.Duration) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+
+	for k, expire := range r.used {
+		if now.After(expire) {
+			delete(r.used, k)
+		}
+	}
+
+	if _, used := r.used[credential]; used {
+		return false
+	}
+
+	r.used[credential] = now.Add(ttl)
+
+	return true
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayFilter(t *testing.T) {
+	filter := newReplayFilter()
+
+	steps := []struct {
+		name       string
+		credential string
+		ttl        time.Duration
+		ok         bool
+	}{
+		{"first use", "code:1", time.Minute, true},
+		{"replay", "code:1", time.Minute, false},
+		{"other credential", "code:2", time.Minute, true},
+		{"expired credential is first used", "code:3", -time.Second, true},
+		{"expired credential can be used again", "code:3", time.Minute, true},
+		{"replay after expired use", "code:3", time.Minute, false},
+	}
+
+	for _, step := range steps {
+		if ok := filter.check(step.credential, step.ttl); ok != step.ok {
+			t.Errorf("%s: check(%s) = %v, want %v", step.name, step.credential, ok, step.ok)
+		}
+	}
+
+	filter.check("code:4", -time.Second)
+	filter.check("code:5", time.Minute)
+
+	if _, ok := filter.used["code:4"]; ok {
+		t.Error("expired code:4 is not removed")
+	}
+}
@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSecret = "BUSQLWBHDAOC6EWJIOKMNLWQMLJOCD6S"
+
+// genTestToken generate a token of timestamp like GenToken.
+func genTestToken(t *testing.T, user, secret string, timestamp int64) string {
+	t.Helper()
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		t.Fatalf("decode secret failed: %v", err)
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatalf("generate nonce failed: %v", err)
+	}
+
+	return strconv.FormatInt(timestamp, 10) + "." + hex.EncodeToString(nonce) + "." +
+		hex.EncodeToString(tokenMAC(key, user, timestamp, nonce))
+}
+
+func TestVerifyToken(t *testing.T) {
+	const period = 60
+
+	now := time.Now().Unix()
+
+	fresh, err := GenToken("alice", testSecret)
+	if err != nil {
+		t.Fatalf("GenToken failed: %v", err)
+	}
+
+	fields := strings.Split(fresh, ".")
+
+	tests := []struct {
+		name   string
+		token  string
+		user   string
+		secret string
+		ok     bool
+	}{
+		{"fresh", fresh, "alice", testSecret, true},
+		{"skew in period", genTestToken(t, "alice", testSecret, now-period+5), "alice", testSecret, true},
+		{"future skew in period", genTestToken(t, "alice", testSecret, now+period-5), "alice", testSecret, true},
+		{"expired", genTestToken(t, "alice", testSecret, now-period-5), "alice", testSecret, false},
+		{"too far in future", genTestToken(t, "alice", testSecret, now+period+5), "alice", testSecret, false},
+		{"other user", fresh, "bob", testSecret, false},
+		{"other secret", fresh, "alice", "RWR2C6BS3PPJCDPQUVW5ICDKXAEJ5UEJ", false},
+		{"changed timestamp", strconv.FormatInt(now-1, 10) + "." + fields[1] + "." + fields[2], "alice", testSecret, false},
+		{"changed nonce", fields[0] + "." + strings.Repeat("00", nonceSize) + "." + fields[2], "alice", testSecret, false},
+		{"short nonce", fields[0] + ".00." + fields[2], "alice", testSecret, false},
+		{"invalid mac", fields[0] + "." + fields[1] + ".zz", "alice", testSecret, false},
+		{"invalid timestamp", "now." + fields[1] + "." + fields[2], "alice", testSecret, false},
+		{"missing field", fields[0] + "." + fields[1], "alice", testSecret, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok, err := verifyToken(tt.token, tt.user, tt.secret, period)
+			if err != nil {
+				t.Fatalf("verifyToken failed: %v", err)
+			}
+
+			if ok != tt.ok {
+				t.Errorf("verifyToken ok = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestIsToken(t *testing.T) {
+	tests := []struct {
+		credential string
+		token      bool
+	}{
+		{"12345678", false},
+		{"", false},
+		{"1.2", false},
+		{"1.2.3", true},
+	}
+
+	for _, tt := range tests {
+		if token := IsToken(tt.credential); token != tt.token {
+			t.Errorf("IsToken(%q) = %v, want %v", tt.credential, token, tt.token)
+		}
+	}
+}
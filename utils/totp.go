@@ -15,6 +15,9 @@ const (
 	algorithm     = otp.AlgorithmSHA512
 	digits        = otp.DigitsEight
 	DefaultPeriod = 60
+
+	// otpDefaultPeriod is the period used by otp when period is 0.
+	otpDefaultPeriod = 30
 )
 
 func GenTOTPSecret(period uint) string {
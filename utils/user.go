@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+// DefaultUser is the user name when client doesn't send one, it uses the top level secret.
+const DefaultUser = "default"
+
+type User struct {
+	Name   string
+	Secret string
+	Period uint
+}
+
+// Users verify TOTP code or token with the secret of the user, every credential can
+// only be used once.
+type Users struct {
+	users   map[string]User
+	filters map[string]*replayFilter
+}
+
+func NewUsers(users ...User) *Users {
+	u := &Users{
+		users:   make(map[string]User, len(users)),
+		filters: make(map[string]*replayFilter, len(users)),
+	}
+
+	for _, user := range users {
+		// GenCode and VerifyCode use the otp default period when period is 0
+		if user.Period == 0 {
+			user.Period = otpDefaultPeriod
+		}
+
+		u.users[user.Name] = user
+		u.filters[user.Name] = newReplayFilter()
+	}
+
+	return u
+}
+
+// Update return a new Users of users, the replay filters of the kept users are reused, so a
+// used credential can't be replayed after update.
+func (u *Users) Update(users ...User) *Users {
+	updated := NewUsers(users...)
+
+	for name := range updated.filters {
+		if filter, ok := u.filters[name]; ok {
+			updated.filters[name] = filter
+		}
+	}
+
+	return updated
+}
+
+// Verify verify credential with the secret of user name, credential can be a TOTP code or a
+// token generated by GenToken, unknown user or used credential is never ok.
+func (u *Users) Verify(name, credential string) (ok bool, err error) {
+	user, exist := u.users[name]
+	if !exist {
+		return false, nil
+	}
+
+	// both TOTP code and token are valid for one period on either side
+	ttl := 2 * time.Duration(user.Period) * time.Second
+
+	if IsToken(credential) {
+		nonce, ok, err := verifyToken(credential, name, user.Secret, user.Period)
+		if err != nil {
+			return false, xerrors.Errorf("verify user %s failed: %w", name, err)
+		}
+
+		return ok && u.filters[name].check("token:"+nonce, ttl), nil
+	}
+
+	ok, err = VerifyCode(credential, user.Secret, user.Period)
+	if err != nil {
+		return false, xerrors.Errorf("verify user %s failed: %w", name, err)
+	}
+
+	return ok && u.filters[name].check("code:"+credential, ttl), nil
+}
+
+// Consume mark TOTP code of user name as used, a code sent with a verified token is consumed, so
+// it can't be replayed alone.
+func (u *Users) Consume(name, code string) {
+	user, exist := u.users[name]
+	if !exist || code == "" {
+		return
+	}
+
+	u.filters[name].check("code:"+code, 2*time.Duration(user.Period)*time.Second)
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUsersVerify(t *testing.T) {
+	users := NewUsers(
+		User{Name: DefaultUser, Secret: testSecret, Period: 60},
+		User{Name: "alice", Secret: "RWR2C6BS3PPJCDPQUVW5ICDKXAEJ5UEJ", Period: 60},
+	)
+
+	code, err := GenCode(testSecret, 60)
+	if err != nil {
+		t.Fatalf("GenCode failed: %v", err)
+	}
+
+	token, err := GenToken(DefaultUser, testSecret)
+	if err != nil {
+		t.Fatalf("GenToken failed: %v", err)
+	}
+
+	aliceToken, err := GenToken("alice", "RWR2C6BS3PPJCDPQUVW5ICDKXAEJ5UEJ")
+	if err != nil {
+		t.Fatalf("GenToken failed: %v", err)
+	}
+
+	expired := genTestToken(t, DefaultUser, testSecret, time.Now().Unix()-120)
+
+	steps := []struct {
+		name       string
+		user       string
+		credential string
+		ok         bool
+	}{
+		{"code", DefaultUser, code, true},
+		{"replayed code", DefaultUser, code, false},
+		{"token", DefaultUser, token, true},
+		{"replayed token", DefaultUser, token, false},
+		{"token of other user", DefaultUser, aliceToken, false},
+		{"user token", "alice", aliceToken, true},
+		{"replayed user token", "alice", aliceToken, false},
+		{"expired token", DefaultUser, expired, false},
+		{"unknown user", "bob", token, false},
+		{"wrong code", DefaultUser, "00000000", false},
+		{"short code", DefaultUser, "1234", false},
+	}
+
+	for _, step := range steps {
+		ok, err := users.Verify(step.user, step.credential)
+		if err != nil {
+			t.Fatalf("%s: Verify failed: %v", step.name, err)
+		}
+
+		if ok != step.ok {
+			t.Errorf("%s: Verify = %v, want %v", step.name, ok, step.ok)
+		}
+	}
+}
+
+func TestUsersConsume(t *testing.T) {
+	users := NewUsers(User{Name: DefaultUser, Secret: testSecret, Period: 60})
+
+	code, err := GenCode(testSecret, 60)
+	if err != nil {
+		t.Fatalf("GenCode failed: %v", err)
+	}
+
+	// the code sent with a verified token is consumed
+	users.Consume(DefaultUser, code)
+
+	if ok, _ := users.Verify(DefaultUser, code); ok {
+		t.Error("consumed code is verified")
+	}
+
+	// consume an unknown user or empty code does nothing
+	users.Consume("bob", code)
+	users.Consume(DefaultUser, "")
+}
+
+func TestUsersUpdateKeepFilter(t *testing.T) {
+	users := NewUsers(User{Name: DefaultUser, Secret: testSecret, Period: 60})
+
+	token, err := GenToken(DefaultUser, testSecret)
+	if err != nil {
+		t.Fatalf("GenToken failed: %v", err)
+	}
+
+	if ok, _ := users.Verify(DefaultUser, token); !ok {
+		t.Fatal("token is not verified")
+	}
+
+	updated := users.Update(
+		User{Name: DefaultUser, Secret: testSecret, Period: 60},
+		User{Name: "alice", Secret: testSecret, Period: 60},
+	)
+
+	if ok, _ := updated.Verify(DefaultUser, token); ok {
+		t.Error("used token is verified after update")
+	}
+}
+
+func TestUsersDefaultPeriod(t *testing.T) {
+	users := NewUsers(User{Name: DefaultUser, Secret: testSecret})
+
+	// period 0 means the otp default period for both sides
+	code, err := GenCode(testSecret, 0)
+	if err != nil {
+		t.Fatalf("GenCode failed: %v", err)
+	}
+
+	if ok, err := users.Verify(DefaultUser, code); err != nil || !ok {
+		t.Errorf("Verify = %v, %v, want true", ok, err)
+	}
+}